@@ -0,0 +1,65 @@
+package wifi
+
+import (
+    "sort"
+)
+
+// aging keeps track of when every access point was last observed by an algorithm,
+// so data about access points that have been removed from the map can be evicted.
+// Age is measured in reads: feeding data marks access points as seen, but does not age them.
+type aging struct {
+    // The number of reads after which an access point that has not been seen is stale. Aging is disabled when 0.
+    limit int
+    reads int
+    lastSeen map[int]int
+}
+
+func newAging(limit int) aging {
+    if limit < 0 {
+        err_string := "** err: Aging limit cannot be negative"
+        panic(err_string)
+    }
+    return aging{limit, 0, make(map[int]int)}
+}
+
+func (a *aging) enabled() bool {
+    return a.limit > 0
+}
+
+// Marks the access points in the signals as seen
+func (a *aging) observe(signals Signals) {
+    if !a.enabled() {
+        return
+    }
+    for _, signal := range signals {
+        a.lastSeen[signal.id] = a.reads
+    }
+}
+
+// Counts a read, and returns the IDs of the access points that have become stale, sorted by ID.
+// Stale access points are only checked every quarter of the limit, so they are evicted between 1 and 1.25 times the limit after they were last seen.
+func (a *aging) read() []int {
+    if !a.enabled() {
+        return nil
+    }
+    a.reads += 1
+    interval := a.limit / 4
+    if interval == 0 {
+        interval = 1
+    }
+    if a.reads % interval != 0 {
+        return nil
+    }
+
+    var stale []int
+    for id, lastSeen := range a.lastSeen {
+        if a.reads - lastSeen > a.limit {
+            stale = append(stale, id)
+        }
+    }
+    for _, id := range stale {
+        delete(a.lastSeen, id)
+    }
+    sort.Ints(stale)
+    return stale
+}
@@ -0,0 +1,215 @@
+package wifi
+
+import (
+    "math"
+    "sort"
+)
+
+// The range of signal strengths in dB, over which signals of access points that were never seen in a cell are spread uniformly
+const signalRange = 60.0
+
+// The prior variance in dB^2 of the signal strength of an access point in a cell, and how many samples it weighs
+const priorSignalVariance = 16.0
+const priorSignalWeight = 2.0
+
+///////////////////
+// Bayesian Grid //
+///////////////////
+
+// A bayesianGrid divides the map in square cells, and learns the likelihood of the signal strength of every access point
+// in every cell from the seed readings. A read computes the posterior probability of every cell with a uniform prior.
+type bayesianGrid struct {
+    cellSize float64
+    // Whether the estimate is the most probable cell, or the expected location
+    maximum bool
+    cells map[cellKey]*bayesianCell
+    // The cells in which every access point was seen
+    index map[int][]cellKey
+}
+
+type cellKey [2]int
+
+type bayesianCell struct {
+    readings int
+    accessPoints map[int]*signalStatistics
+    // The access points seen in the cell, in the order they were first seen
+    ids []int
+}
+
+// The probability of a square cell for a reading, for visualisation of the posterior
+type CellProbability struct {
+    // The center of the cell, and its width in m
+    Location *Location
+    Size float64
+    Probability float64
+}
+
+// Algorithms that compute a posterior over locations implement posteriorEstimator
+type posteriorEstimator interface {
+    posterior(signals Signals) []CellProbability
+}
+
+// Returns the posterior probability of every location the given algorithm considers for the signals.
+// The second return value is false if the algorithm does not compute a posterior.
+func Posterior(a algorithm, signals Signals) ([]CellProbability, bool) {
+    estimator, ok := a.(posteriorEstimator)
+    if !ok {
+        return nil, false
+    }
+    return estimator.posterior(signals), true
+}
+
+// Returns a Bayesian grid with cells of the given size in m that estimates the expected location
+func NewBayesianGrid(cellSize float64) algorithm {
+    return newBayesianGrid(cellSize, false)
+}
+
+// Returns a Bayesian grid with cells of the given size in m that estimates the center of the most probable cell
+func NewMAPBayesianGrid(cellSize float64) algorithm {
+    return newBayesianGrid(cellSize, true)
+}
+
+func newBayesianGrid(cellSize float64, maximum bool) *bayesianGrid {
+    if cellSize <= 0 {
+        err_string := "** err: Cell Size must be positive"
+        panic(err_string)
+    }
+    return &bayesianGrid{cellSize, maximum, make(map[cellKey]*bayesianCell), make(map[int][]cellKey)}
+}
+
+// Reads only compute the posterior, and do not modify the grid
+func (b *bayesianGrid) sequential() bool {
+    return false
+}
+
+func (b *bayesianGrid) feed(signals Signals, location *Location) {
+    key := cellKey{int(math.Floor(location.X / b.cellSize)), int(math.Floor(location.Y / b.cellSize))}
+    cell, exists := b.cells[key]
+    if !exists {
+        cell = &bayesianCell{0, make(map[int]*signalStatistics), nil}
+        b.cells[key] = cell
+    }
+    cell.readings += 1
+    for _, signal := range signals {
+        statistics, exists := cell.accessPoints[signal.id]
+        if !exists {
+            statistics = &signalStatistics{}
+            cell.accessPoints[signal.id] = statistics
+            cell.ids = append(cell.ids, signal.id)
+            b.index[signal.id] = append(b.index[signal.id], key)
+        }
+        statistics.add(signal.signalStrength)
+    }
+}
+
+func (b *bayesianGrid) read(signals Signals, realLocation *Location) (*Estimate, bool) {
+    posterior, bestLikelihood := b.cellPosterior(signals)
+    if len(posterior) == 0 {
+        return nil, false
+    }
+    var location *Location
+    if b.maximum {
+        best := posterior[0]
+        for _, cell := range posterior {
+            if cell.Probability > best.Probability {
+                best = cell
+            }
+        }
+        location = best.Location
+    } else {
+        var x, y float64
+        for _, cell := range posterior {
+            x += cell.Probability * cell.Location.X
+            y += cell.Probability * cell.Location.Y
+        }
+        location = NewLocation(x, y)
+    }
+
+    // The spread of the posterior, and the spread of a uniform location within a cell.
+    // The match distance is the negative log likelihood per access point of the most likely cell.
+    var variance float64
+    for _, cell := range posterior {
+        d := distance(cell.Location, location)
+        variance += cell.Probability * d * d
+    }
+    variance += b.cellSize * b.cellSize / 6
+    return &Estimate{location, math.Sqrt(variance), len(signals), -bestLikelihood / float64(len(signals)), nil, nil}, true
+}
+
+func (b *bayesianGrid) posterior(signals Signals) []CellProbability {
+    posterior, _ := b.cellPosterior(signals)
+    return posterior
+}
+
+// Returns the posterior probability of every cell in which at least one of the access points of the signals was seen,
+// and the log likelihood of the most likely cell. Other cells have a negligible probability. Cells are sorted by location.
+func (b *bayesianGrid) cellPosterior(signals Signals) ([]CellProbability, float64) {
+    candidates := make(map[cellKey]bool)
+    for _, signal := range signals {
+        for _, key := range b.index[signal.id] {
+            candidates[key] = true
+        }
+    }
+    keys := make([]cellKey, 0, len(candidates))
+    for key, _ := range candidates {
+        keys = append(keys, key)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+    })
+
+    likelihoods := make([]float64, len(keys))
+    maximum := math.Inf(-1)
+    for i, key := range keys {
+        likelihoods[i] = b.logLikelihood(signals, key)
+        maximum = math.Max(maximum, likelihoods[i])
+    }
+    posterior := make([]CellProbability, len(keys))
+    var total float64
+    for i, key := range keys {
+        probability := math.Exp(likelihoods[i] - maximum)
+        posterior[i] = CellProbability{NewLocation((float64(key[0]) + 0.5) * b.cellSize, (float64(key[1]) + 0.5) * b.cellSize), b.cellSize, probability}
+        total += probability
+    }
+    for i, _ := range posterior {
+        posterior[i].Probability /= total
+    }
+    return posterior, maximum
+}
+
+// Returns the log likelihood of the signals in the cell. Every access point is detected in a cell with a probability
+// estimated from the seed readings, and has a normally distributed signal strength when it is detected.
+func (b *bayesianGrid) logLikelihood(signals Signals, key cellKey) float64 {
+    cell, exists := b.cells[key]
+    if !exists {
+        return math.Inf(-1)
+    }
+    readings := float64(cell.readings)
+    var likelihood float64
+    heard := 0
+    for _, signal := range signals {
+        statistics, exists := cell.accessPoints[signal.id]
+        if !exists {
+            likelihood += math.Log(1 / (readings + 2) / signalRange)
+            continue
+        }
+        heard += 1
+        detection := (float64(statistics.count) + 1) / (readings + 2)
+        variance := (statistics.m2 + priorSignalVariance * priorSignalWeight) / (float64(statistics.count) - 1 + priorSignalWeight)
+        difference := signal.signalStrength - statistics.mean
+        likelihood += math.Log(detection) - 0.5 * math.Log(2 * math.Pi * variance) - difference * difference / (2 * variance)
+    }
+    // Access points of the cell that are missing from the signals
+    if heard < len(cell.accessPoints) {
+        ids := make(map[int]bool, len(signals))
+        for _, signal := range signals {
+            ids[signal.id] = true
+        }
+        for _, id := range cell.ids {
+            if !ids[id] {
+                likelihood += math.Log(1 - (float64(cell.accessPoints[id].count) + 1) / (readings + 2))
+            }
+        }
+    }
+    return likelihood
+}
@@ -15,18 +15,31 @@ func main() {
     configuration.TestCycles = 50
     configuration.ReplacementRate = 0.1
     configuration.ReplacementStrategy = wifi.FiFoReplacement
+    // configuration.AnchorCount = 10
+    // configuration.RogueAccessPoints = 20
+    // configuration.PoisonedReadings = 50
 
     engine := wifi.NewEngine(configuration)
     engine.AddAlgorithm("Centroid", wifi.NewCentroid())
     engine.AddAlgorithm("Learning Centroid", wifi.NewLearningCentroid())
     engine.AddAlgorithm("Smart Learning Centroid", wifi.NewSmartLearningCentroid())
-    engine.AddAlgorithm("Enhanced Centroid", wifi.NewEnhancedCentroid())
     engine.AddAlgorithm("Enhanced Learning Centroid", wifi.NewEnhancedLearningCentroid())
+    // engine.AddAlgorithm("Guarded Learning Centroid", wifi.NewLearningCentroid(wifi.CentroidConfidence(20), wifi.CentroidFeedbackWeight(0.5)))
+    // engine.AddAlgorithm("Aging Learning Centroid", wifi.NewLearningCentroid(wifi.CentroidAging(5000)))
+    // engine.AddAlgorithm("Window Learning Centroid", wifi.NewLearningCentroid(wifi.CentroidWindow(300)))
     // engine.AddAlgorithm("Fingerprinting", wifi.NewFingerprinting())
     // engine.AddAlgorithm("Learning Fingerprinting", wifi.NewLearningFingerprinting())
+    // engine.AddAlgorithm("Aging Learning Fingerprinting", wifi.NewLearningFingerprinting(wifi.FingerprintAging(5000)))
     // engine.AddAlgorithm("Fingerprinting", wifi.NewSmartLearningFingerprinting())
     // engine.AddAlgorithm("Enhanced Fingerprinting", wifi.NewEnhancedFingerprinting())
     // engine.AddAlgorithm("Enhanced Learning Fingerprinting", wifi.NewEnhancedLearningFingerprinting())
+    engine.AddAlgorithm("WKNN Fingerprinting", wifi.NewWKNNFingerprinting(4, wifi.EuclideanDistance, -100))
+    engine.AddAlgorithm("Rank Fingerprinting", wifi.NewRankFingerprinting(4, wifi.SpearmanDistance))
+    // engine.AddAlgorithm("Calibrated Learning Fingerprinting", wifi.NewCalibration(wifi.NewLearningFingerprinting()))
+    // engine.AddAlgorithm("Uncertainty Ensemble", wifi.NewUncertaintyEnsemble(wifi.NewCentroid(), wifi.NewWKNNFingerprinting(4, wifi.EuclideanDistance, -100)))
+    // engine.AddAlgorithm("Bayesian Grid", wifi.NewBayesianGrid(10))
+    // engine.AddAlgorithm("Learning WLS", wifi.NewLearningWLSLocalization())
+    // engine.AddAlgorithm("RANSAC Centroid", wifi.NewCentroid(wifi.CentroidRANSAC(0)))
     engine.Run()
 
     // wifi.Test()
@@ -0,0 +1,236 @@
+package wifi
+
+import (
+    "math"
+)
+
+// Readings of the reference receiver within this distance in m of a reading of another receiver are considered co-located
+const calibrationRadius = 10.0
+
+// The number of access points read at the same location by a device and the reference receiver, before the device is
+// calibrated, and before its slope is estimated
+const minCalibrationSamples = 3
+const minSlopeSamples = 30
+
+// The number of readings of a device after which its calibration is estimated again.
+// During its first interval, a device is calibrated after every reading.
+const calibrationInterval = 50
+
+// The number of times pairs are selected again with the new calibration, when estimating it
+const calibrationIterations = 3
+
+// The signal strength in dBm at which calibrations are evaluated
+const calibrationSignalStrength = -70.0
+
+/////////////////
+// Calibration //
+/////////////////
+
+// Calibration wraps an algorithm, and calibrates the signals of every device to the device the algorithm is fed with.
+// Devices are calibrated by comparing the access points they share with readings of the reference device at the same location.
+type calibration struct {
+    algorithm algorithm
+    // Whether the slope is estimated in addition to the offset
+    slope bool
+    // The receiver that feeds the algorithm, to which other devices are calibrated
+    reference string
+    hasReference bool
+    // The readings of the reference receiver by cell of calibrationRadius
+    readings map[cellKey][]calibrationReading
+    // The signal strengths of the access points every receiver shares with co-located reference readings
+    pairs map[string][]calibrationPair
+    // The estimated calibration and the number of readings per receiver
+    estimates map[string]deviceCalibration
+    reads map[string]int
+}
+
+type calibrationReading struct {
+    location *Location
+    signals Signals
+}
+
+// The signal strength of an access point read by the reference receiver and another receiver at the same location,
+// and the weakest signal strength of both readings. Weaker signals may have been dropped by the device.
+type calibrationPair struct {
+    reference, device float64
+    referenceFloor, deviceFloor float64
+}
+
+// A device reports Slope * signal strength + Offset, where the signal strength is as reported by the reference device
+type deviceCalibration struct {
+    slope, offset float64
+}
+
+// Returns the algorithm with the signals of every device calibrated by their offset
+func NewCalibration(a algorithm) algorithm {
+    return newCalibration(a, false)
+}
+
+// Returns the algorithm with the signals of every device calibrated by their slope and offset
+func NewSlopeCalibration(a algorithm) algorithm {
+    return newCalibration(a, true)
+}
+
+func newCalibration(a algorithm, slope bool) *calibration {
+    return &calibration{a, slope, "", false, make(map[cellKey][]calibrationReading), make(map[string][]calibrationPair), make(map[string]deviceCalibration), make(map[string]int)}
+}
+
+func (c *calibration) unwrap() algorithm {
+    return c.algorithm
+}
+
+// Calibrations are learned from every read
+func (c *calibration) sequential() bool {
+    return true
+}
+
+func (c *calibration) feed(signals Signals, location *Location) {
+    c.feedDevice(signals, location, nil)
+}
+
+func (c *calibration) read(signals Signals, location *Location) (*Estimate, bool) {
+    return c.readDevice(signals, location, nil)
+}
+
+func (c *calibration) feedDevice(signals Signals, location *Location, device *Device) {
+    receiver := device.receiver()
+    if !c.hasReference {
+        c.reference = receiver
+        c.hasReference = true
+    }
+    if receiver == c.reference {
+        reading := make(Signals, len(signals))
+        copy(reading, signals)
+        cell := c.cell(location)
+        c.readings[cell] = append(c.readings[cell], calibrationReading{location, reading})
+    } else {
+        c.observe(receiver, signals, location)
+    }
+    feedDevice(c.algorithm, c.calibrate(receiver, signals), location, device)
+}
+
+func (c *calibration) readDevice(signals Signals, location *Location, device *Device) (*Estimate, bool) {
+    receiver := device.receiver()
+    if receiver != c.reference {
+        c.observe(receiver, signals, location)
+    }
+    return readDevice(c.algorithm, c.calibrate(receiver, signals), location, device)
+}
+
+func (c *calibration) cell(location *Location) cellKey {
+    return cellKey{int(math.Floor(location.X / calibrationRadius)), int(math.Floor(location.Y / calibrationRadius))}
+}
+
+// Returns the reference reading closest to the location within calibrationRadius, or nil if there is none
+func (c *calibration) colocated(location *Location) *calibrationReading {
+    var closest *calibrationReading
+    closestDistance := calibrationRadius
+    center := c.cell(location)
+    for x := center[0] - 1; x <= center[0] + 1; x++ {
+        for y := center[1] - 1; y <= center[1] + 1; y++ {
+            readings := c.readings[cellKey{x, y}]
+            for i, reading := range readings {
+                if d := distance(reading.location, location); d <= closestDistance {
+                    closest = &readings[i]
+                    closestDistance = d
+                }
+            }
+        }
+    }
+    return closest
+}
+
+// Pairs the signals the receiver read at the location with a co-located reference reading, and estimates its calibration
+func (c *calibration) observe(receiver string, signals Signals, location *Location) {
+    if reference := c.colocated(location); reference != nil && len(signals) > 0 {
+        strengths := make(map[int]float64, len(reference.signals))
+        referenceFloor := math.Inf(1)
+        for _, signal := range reference.signals {
+            strengths[signal.id] = signal.signalStrength
+            referenceFloor = math.Min(referenceFloor, signal.signalStrength)
+        }
+        deviceFloor := math.Inf(1)
+        for _, signal := range signals {
+            deviceFloor = math.Min(deviceFloor, signal.signalStrength)
+        }
+        for _, signal := range signals {
+            if strength, exists := strengths[signal.id]; exists {
+                c.pairs[receiver] = append(c.pairs[receiver], calibrationPair{strength, signal.signalStrength, referenceFloor, deviceFloor})
+            }
+        }
+    }
+    c.reads[receiver] += 1
+    reads := c.reads[receiver]
+    if reads <= calibrationInterval || reads % calibrationInterval == 0 {
+        c.estimate(receiver)
+    }
+}
+
+// Estimates the calibration of the receiver from its pairs. Pairs are only used when both signal strengths, calibrated to
+// the device, are above the floors of both readings, so signals that either receiver may have dropped do not bias it.
+// The slope is fitted with Deming regression, as both signal strengths are noisy.
+func (c *calibration) estimate(receiver string) {
+    estimate, exists := c.estimates[receiver]
+    if !exists {
+        estimate = deviceCalibration{1, 0}
+    }
+    for iteration := 0; iteration < calibrationIterations; iteration++ {
+        var count float64
+        var sumX, sumY, sumXX, sumYY, sumXY float64
+        for _, pair := range c.pairs[receiver] {
+            x := estimate.slope * pair.reference + estimate.offset
+            floor := math.Max(pair.deviceFloor, estimate.slope * pair.referenceFloor + estimate.offset)
+            if x <= floor || pair.device <= floor {
+                continue
+            }
+            count += 1
+            sumX += pair.reference
+            sumY += pair.device
+            sumXX += pair.reference * pair.reference
+            sumYY += pair.device * pair.device
+            sumXY += pair.reference * pair.device
+        }
+        if count < minCalibrationSamples {
+            return
+        }
+        // The device scales the noise of the signals by its slope as well
+        ratio := estimate.slope * estimate.slope
+        meanX, meanY := sumX / count, sumY / count
+        estimate = deviceCalibration{1, meanY - meanX}
+        if c.slope && count >= minSlopeSamples {
+            varianceX := sumXX / count - meanX * meanX
+            varianceY := sumYY / count - meanY * meanY
+            covariance := sumXY / count - meanX * meanY
+            if covariance > 0 {
+                difference := varianceY - ratio * varianceX
+                slope := (difference + math.Sqrt(difference * difference + 4 * ratio * covariance * covariance)) / (2 * covariance)
+                estimate = deviceCalibration{slope, meanY - slope * meanX}
+            }
+        }
+        c.estimates[receiver] = estimate
+    }
+}
+
+// Returns the signals of the receiver as the reference receiver would report them.
+// Signals of receivers without a calibration are returned unchanged.
+func (c *calibration) calibrate(receiver string, signals Signals) Signals {
+    estimate, exists := c.estimates[receiver]
+    if !exists || receiver == c.reference {
+        return signals
+    }
+    calibrated := make(Signals, len(signals))
+    for i, signal := range signals {
+        signal.signalStrength = (signal.signalStrength - estimate.offset) / estimate.slope
+        calibrated[i] = signal
+    }
+    return calibrated
+}
+
+// Returns the estimated calibration of the receiver. Receivers without a calibration report the same as the reference receiver.
+func (c *calibration) deviceCalibration(receiver string) (slope, offset float64) {
+    estimate, exists := c.estimates[receiver]
+    if !exists || receiver == c.reference {
+        return 1, 0
+    }
+    return estimate.slope, estimate.offset
+}
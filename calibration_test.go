@@ -0,0 +1,38 @@
+package wifi
+
+import (
+    "math"
+    "testing"
+)
+
+// Seeds a calibrated centroid with ideal readings, reads with the device, and returns the error of the calibration at -70 dBm
+func calibrationError(t *testing.T, slope bool, device *Device) float64 {
+    m, locations := testMap(400, 1500, 10)
+    c := newCalibration(NewCentroid(), slope)
+    for _, location := range locations {
+        feedDevice(c, m.Read(location), location, nil)
+    }
+    for _, location := range locations {
+        location = NewLocation(location.X + 3, location.Y + 4)
+        readDevice(c, m.ReadDevice(location, device), location, device)
+    }
+    estimatedSlope, estimatedOffset := c.deviceCalibration(device.Name)
+    gain, offset := device.response()
+    return math.Abs(estimatedSlope * calibrationSignalStrength + estimatedOffset - (gain * calibrationSignalStrength + offset))
+}
+
+func TestCalibrationWithSensitivity(t *testing.T) {
+    for _, sensitivity := range []float64{0, -85, -80} {
+        device := &Device{Name: "Device", Gain: 1, Offset: -6, Sensitivity: sensitivity}
+        if err := calibrationError(t, false, device); err > 0.5 {
+            t.Errorf("offset calibration with a sensitivity of %.0f dBm is %.2f dB off", sensitivity, err)
+        }
+    }
+}
+
+func TestSlopeCalibrationWithSensitivity(t *testing.T) {
+    device := &Device{Name: "Device", Gain: 0.9, Offset: 3, Sensitivity: -85, MaxAccessPoints: 10}
+    if err := calibrationError(t, true, device); err > 1 {
+        t.Errorf("slope calibration is %.2f dB off", err)
+    }
+}
@@ -1,98 +1,444 @@
 package wifi
 
+import (
+    "math"
+    "sort"
+)
+
 type centroidAccessPoint struct {
-    x, y []float64
+    count int
+    weight, sumX, sumY float64
+    // The samples in the window, where next is the oldest
+    x, y, w []float64
+    next int
     location *Location
 }
 
+// Returns the number of samples the location of the access point is based on
+func (accessPoint *centroidAccessPoint) samples(c *centroid) int {
+    if c.estimator == centroidWindow {
+        return len(accessPoint.x)
+    }
+    return accessPoint.count
+}
+
+// Ways to learn access point locations from samples
+const (
+    centroidMean = iota
+    centroidMovingAverage
+    centroidWindow
+)
+
+// Ways to combine the access point locations of a reading into an estimate
+const (
+    centroidCombineMean = iota
+    centroidCombineMedian
+    centroidCombineRANSAC
+)
+
+// The number of recent samples smart learning learns from
+const smartWindow = 300
+
 type centroid struct {
     accessPointMap map[int]centroidAccessPoint
     minMatches int
-    enhanced bool
     learning bool
-    smart bool
+    aging aging
+    relocation relocationDetection
+    estimator int
+    alpha float64
+    window int
+    confidence float64
+    feedbackWeight float64
+    combiner int
+    inlierDistance float64
 }
 
-func NewCentroid() algorithm {
-    return algorithm(&centroid{make(map[int]centroidAccessPoint), 4, false, false, false})
+// A CentroidOption configures a centroid algorithm
+type CentroidOption func(c *centroid)
+
+// Forgets the learned location of access points that have not been seen for the given number of reads
+func CentroidAging(reads int) CentroidOption {
+    return func(c *centroid) {
+        c.aging = newAging(reads)
+    }
 }
 
-func NewEnhancedCentroid() algorithm {
-    return algorithm(&centroid{make(map[int]centroidAccessPoint), 4, true, false, false})
+// Learns access point locations as an exponential moving average, where every new sample has the given weight
+func CentroidMovingAverage(alpha float64) CentroidOption {
+    if alpha <= 0 || alpha > 1 {
+        err_string := "** err: Moving average weight must be between 0 and 1"
+        panic(err_string)
+    }
+    return func(c *centroid) {
+        c.estimator = centroidMovingAverage
+        c.alpha = alpha
+    }
 }
 
-func NewLearningCentroid() algorithm {
-    return algorithm(&centroid{make(map[int]centroidAccessPoint), 4, false, true, false})
+// Learns access point locations as the mean of the given number of most recent samples
+func CentroidWindow(size int) CentroidOption {
+    if size < 1 {
+        err_string := "** err: Window size must be at least 1"
+        panic(err_string)
+    }
+    return func(c *centroid) {
+        c.estimator = centroidWindow
+        c.window = size
+    }
 }
 
-func NewEnhancedLearningCentroid() algorithm {
-    return algorithm(&centroid{make(map[int]centroidAccessPoint), 4, true, true, false})
+// Forgets an access point that is more than threshold meters from the other access points it is seen with, count times in a row
+func CentroidRelocationDetection(threshold float64, count int) CentroidOption {
+    return func(c *centroid) {
+        c.relocation = newRelocationDetection(threshold, count)
+    }
 }
 
-func NewSmartLearningCentroid() algorithm {
-    return algorithm(&centroid{make(map[int]centroidAccessPoint), 4, false, true, true})
+// Estimates the location as the coordinate-wise median, and rejects access points further than the given distance in m.
+// A distance of 0 uses the maximum reception distance.
+func CentroidMedian(distance float64) CentroidOption {
+    if distance < 0 {
+        err_string := "** err: Inlier distance cannot be negative"
+        panic(err_string)
+    }
+    return func(c *centroid) {
+        c.combiner = centroidCombineMedian
+        c.inlierDistance = distance
+    }
+}
+
+// Estimates the location as the mean of the largest set of access points within the given distance in m of their mean.
+// A distance of 0 uses the maximum reception distance.
+func CentroidRANSAC(distance float64) CentroidOption {
+    if distance < 0 {
+        err_string := "** err: Inlier distance cannot be negative"
+        panic(err_string)
+    }
+    return func(c *centroid) {
+        c.combiner = centroidCombineRANSAC
+        c.inlierDistance = distance
+    }
+}
+
+// Only learns from estimates with a radius of at most the given distance in m
+func CentroidConfidence(radius float64) CentroidOption {
+    if radius < 0 {
+        err_string := "** err: Confidence radius cannot be negative"
+        panic(err_string)
+    }
+    return func(c *centroid) {
+        c.confidence = radius
+    }
+}
+
+// Gives learned samples the given weight, where seed samples weigh 1
+func CentroidFeedbackWeight(weight float64) CentroidOption {
+    if weight <= 0 || weight > 1 {
+        err_string := "** err: Feedback weight must be between 0 and 1"
+        panic(err_string)
+    }
+    return func(c *centroid) {
+        c.feedbackWeight = weight
+    }
+}
+
+// Smart algorithms learn from a window of recent samples, guarded algorithms only from confident estimates with a lower weight
+func newCentroid(guarded, learning, smart bool, options []CentroidOption) *centroid {
+    c := &centroid{make(map[int]centroidAccessPoint), 4, learning, newAging(0), newRelocationDetection(0, 0), centroidMean, 0, 0, 0, 1, centroidCombineMean, 0}
+    if smart {
+        c.estimator = centroidWindow
+        c.window = smartWindow
+    }
+    if guarded {
+        c.confidence = defaultConfidenceRadius
+        c.feedbackWeight = learnedSampleWeight
+    }
+    for _, option := range options {
+        option(c)
+    }
+    return c
+}
+
+func NewCentroid(options ...CentroidOption) algorithm {
+    return algorithm(newCentroid(false, false, false, options))
+}
+
+// Same estimates as NewCentroid, since the guards against drift only apply to learning, see NewEnhancedLearningCentroid
+func NewEnhancedCentroid(options ...CentroidOption) algorithm {
+    return algorithm(newCentroid(true, false, false, options))
+}
+
+func NewLearningCentroid(options ...CentroidOption) algorithm {
+    return algorithm(newCentroid(false, true, false, options))
+}
+
+func NewEnhancedLearningCentroid(options ...CentroidOption) algorithm {
+    return algorithm(newCentroid(true, true, false, options))
+}
+
+func NewSmartLearningCentroid(options ...CentroidOption) algorithm {
+    return algorithm(newCentroid(false, true, true, options))
 }
 
 func (c *centroid) feed(signals Signals, location *Location) {
+    c.feedWeighted(signals, location, 1)
+}
+
+func (c *centroid) feedWeighted(signals Signals, location *Location, weight float64) {
+    c.aging.observe(signals)
     var accessPoint centroidAccessPoint
-    var x, y float64
     for _, signal := range signals {
         accessPoint = c.accessPointMap[signal.id]
+        c.learn(&accessPoint, location, weight)
+        c.accessPointMap[signal.id] = accessPoint
+    }
+}
+
+// Adds a sample with the given weight to the access point and updates its location
+func (c *centroid) learn(accessPoint *centroidAccessPoint, location *Location, weight float64) {
+    accessPoint.count += 1
+    if c.estimator == centroidWindow && len(accessPoint.x) == c.window {
+        // Replace the oldest sample
+        i := accessPoint.next
+        accessPoint.sumX -= accessPoint.x[i] * accessPoint.w[i]
+        accessPoint.sumY -= accessPoint.y[i] * accessPoint.w[i]
+        accessPoint.weight -= accessPoint.w[i]
+        accessPoint.x[i] = location.X
+        accessPoint.y[i] = location.Y
+        accessPoint.w[i] = weight
+        accessPoint.next = (i + 1) % c.window
+    } else if c.estimator == centroidWindow {
         accessPoint.x = append(accessPoint.x, location.X)
         accessPoint.y = append(accessPoint.y, location.Y)
-        if len(accessPoint.x) >= c.minMatches && (!c.smart || len(accessPoint.x) < 300) {
-            x = 0
-            for _, value := range accessPoint.x {
-                x += value
-            }
-            x = x / float64(len(accessPoint.x))
+        accessPoint.w = append(accessPoint.w, weight)
+    }
+    accessPoint.sumX += location.X * weight
+    accessPoint.sumY += location.Y * weight
+    accessPoint.weight += weight
 
-            y = 0
-            for _, value := range accessPoint.y {
-                y += value
-            }
-            y = y / float64(len(accessPoint.y))
-            accessPoint.location = NewLocation(x, y)
-        }
-        c.accessPointMap[signal.id] = accessPoint
+    if accessPoint.count < c.minMatches {
+        return
+    }
+
+    if c.estimator == centroidMovingAverage && accessPoint.location != nil {
+        accessPoint.location = NewLocation(
+            accessPoint.location.X + (location.X - accessPoint.location.X) * c.alpha * weight,
+            accessPoint.location.Y + (location.Y - accessPoint.location.Y) * c.alpha * weight)
+    } else {
+        accessPoint.location = NewLocation(accessPoint.sumX / accessPoint.weight, accessPoint.sumY / accessPoint.weight)
     }
 }
 
-func (c *centroid) read(signals Signals, realLocation *Location) (*Location, bool) {
+func (c *centroid) sequential() bool {
+    return c.learning || c.aging.enabled() || c.relocation.enabled()
+}
+
+func (c *centroid) read(signals Signals, realLocation *Location) (*Estimate, bool) {
+    c.aging.observe(signals)
+    for _, id := range c.aging.read() {
+        delete(c.accessPointMap, id)
+    }
+
     var accessPoint centroidAccessPoint
     var xList, yList []float64
+    var ids []int
     var exists bool
     for _, signal := range signals {
         accessPoint, exists = c.accessPointMap[signal.id]
         if exists && accessPoint.location != nil {
             xList = append(xList, accessPoint.location.X)
             yList = append(yList, accessPoint.location.Y)
+            ids = append(ids, signal.id)
         }
     }
+    observed := ids
+    if c.relocation.enabled() {
+        ids, xList, yList = c.detectRelocations(ids, xList, yList)
+    }
     if len(xList) == 0 {
         return nil, false
     } else {
-        var x, y float64 = 0, 0
-        for _, value := range xList {
-            x += value
+        var location *Location
+        var radius float64
+        consistent := ids
+        if c.combiner == centroidCombineMean {
+            var x, y float64 = 0, 0
+            for _, value := range xList {
+                x += value
+            }
+            x = x / float64(len(xList))
+
+            y = 0
+            for _, value := range yList {
+                y += value
+            }
+            y = y / float64(len(yList))
+
+            location = NewLocation(x, y)
+            radius = c.radius(xList, yList, location)
+        } else {
+            location, ids, xList, yList = c.robustCentroid(ids, xList, yList)
+            radius = c.radius(xList, yList, location)
+            if c.combiner == centroidCombineMedian {
+                // The median is less efficient than the mean
+                radius *= math.Sqrt(math.Pi / 2)
+            }
         }
-        x = x / float64(len(xList))
+        estimate := &Estimate{location, radius, len(xList), 0, ids, excluded(observed, ids)}
 
-        y = 0
-        for _, value := range yList {
-            y += value
+        if c.learning && (c.confidence == 0 || estimate.Radius <= c.confidence) {
+            feedback := signals
+            if len(ids) < len(consistent) {
+                feedback = signals.without(excluded(consistent, ids))
+            }
+            c.feedWeighted(feedback, location, c.feedbackWeight)
         }
-        y = y / float64(len(yList))
+        return estimate, true
+    }
+}
 
-        location := NewLocation(x, y)
+// Returns the robust location estimate, and the IDs and locations of the inliers. When there are no inliers, all are kept.
+func (c *centroid) robustCentroid(ids []int, xList, yList []float64) (*Location, []int, []float64, []float64) {
+    var location *Location
+    var inliers []int
+    if c.combiner == centroidCombineMedian {
+        location = NewLocation(median(xList), median(yList))
+        inliers = c.inliers(xList, yList, location)
+    } else {
+        for i, _ := range xList {
+            hypothesis := c.inliers(xList, yList, NewLocation(xList[i], yList[i]))
+            hypothesis = c.inliers(xList, yList, mean(xList, yList, hypothesis))
+            if len(hypothesis) > len(inliers) {
+                inliers = hypothesis
+            }
+        }
+        location = mean(xList, yList, inliers)
+    }
+    if len(inliers) == 0 {
+        return location, ids, xList, yList
+    }
+    keptIDs := make([]int, len(inliers))
+    keptX := make([]float64, len(inliers))
+    keptY := make([]float64, len(inliers))
+    for j, i := range inliers {
+        keptIDs[j], keptX[j], keptY[j] = ids[i], xList[i], yList[i]
+    }
+    return location, keptIDs, keptX, keptY
+}
 
-        if c.enhanced {
-            location.enhance(realLocation)
+// Returns the indices of the access point locations within the inlier distance of the location
+func (c *centroid) inliers(xList, yList []float64, location *Location) []int {
+    inlierDistance := c.inlierDistance
+    if inlierDistance == 0 {
+        inlierDistance = maxReceptionDistance
+    }
+    var inliers []int
+    for i, _ := range xList {
+        if distance(location, NewLocation(xList[i], yList[i])) <= inlierDistance {
+            inliers = append(inliers, i)
         }
+    }
+    return inliers
+}
+
+// Returns the mean of the locations with the given indices, or nil if there are none
+func mean(xList, yList []float64, indices []int) *Location {
+    if len(indices) == 0 {
+        return nil
+    }
+    var x, y float64
+    for _, i := range indices {
+        x += xList[i]
+        y += yList[i]
+    }
+    return NewLocation(x / float64(len(indices)), y / float64(len(indices)))
+}
+
+// Returns the median of the values
+func median(values []float64) float64 {
+    sorted := make([]float64, len(values))
+    copy(sorted, values)
+    sort.Float64s(sorted)
+    middle := len(sorted) / 2
+    if len(sorted) % 2 == 0 {
+        return (sorted[middle - 1] + sorted[middle]) / 2
+    }
+    return sorted[middle]
+}
+
+// Returns the IDs that are not kept, in order, or nil if all of them are kept
+func excluded(ids, kept []int) []int {
+    if len(kept) == len(ids) {
+        return nil
+    }
+    keep := make(map[int]bool, len(kept))
+    for _, id := range kept {
+        keep[id] = true
+    }
+    var rest []int
+    for _, id := range ids {
+        if !keep[id] {
+            rest = append(rest, id)
+        }
+    }
+    return rest
+}
+
+// Returns the expected error of a centroid, the standard error of the access point locations
+func (c *centroid) radius(xList, yList []float64, centroid *Location) float64 {
+    n := float64(len(xList))
+    if len(xList) < 2 {
+        return maxReceptionDistance / math.Sqrt2
+    }
+    locations := make([]*Location, len(xList))
+    for i, _ := range xList {
+        locations[i] = NewLocation(xList[i], yList[i])
+    }
+    // The sample spread, corrected for the centroid being estimated from the same locations
+    return spread(locations, nil, centroid) * math.Sqrt(n / (n - 1)) / math.Sqrt(n)
+}
+
+// Forgets the access points that are relocated, and returns the IDs and locations of the others
+func (c *centroid) detectRelocations(ids []int, xList, yList []float64) ([]int, []float64, []float64) {
+    if len(ids) < 3 {
+        return ids, xList, yList
+    }
+    var sumX, sumY float64
+    for i, _ := range ids {
+        sumX += xList[i]
+        sumY += yList[i]
+    }
+    others := float64(len(ids) - 1)
+    var keptIDs []int
+    var keptX, keptY []float64
+    for i, id := range ids {
+        learned := NewLocation(xList[i], yList[i])
+        observed := NewLocation((sumX - xList[i]) / others, (sumY - yList[i]) / others)
+        if c.relocation.relocated(id, learned, observed) {
+            delete(c.accessPointMap, id)
+        } else {
+            keptIDs = append(keptIDs, id)
+            keptX = append(keptX, xList[i])
+            keptY = append(keptY, yList[i])
+        }
+    }
+    return keptIDs, keptX, keptY
+}
 
-        if c.learning {
-            c.feed(signals, location)
+// Returns the number of access points the algorithm holds data for
+func (c *centroid) databaseSize() int {
+    return len(c.accessPointMap)
+}
+
+// Returns the learned access point locations, sorted by ID
+func (c *centroid) accessPointEstimates() []AccessPointEstimate {
+    estimates := make([]AccessPointEstimate, 0, len(c.accessPointMap))
+    for id, accessPoint := range c.accessPointMap {
+        if accessPoint.location != nil {
+            estimates = append(estimates, AccessPointEstimate{id, accessPoint.location, accessPoint.samples(c)})
         }
-        return location, true
     }
-}
\ No newline at end of file
+    sort.Sort(ByAccessPointID(estimates))
+    return estimates
+}
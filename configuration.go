@@ -1,5 +1,10 @@
 package wifi
 
+import (
+    "fmt"
+    "math"
+)
+
 // Configuration options
 type Configuration struct {
     // The height of the map in meters
@@ -11,43 +16,372 @@ type Configuration struct {
     // The amount of accesspoints on the map in ap/km^2
     AccessPointDensity int
 
+    // The way access points are placed on the map. Can be UniformPlacement, ThomasPlacement, MaternPlacement, GridPlacement or RasterPlacement.
+    // When set to 0, UniformPlacement is used.
+    Placement int
+
+    // The mean number of access points per cluster for ThomasPlacement and MaternPlacement
+    ClusterSize float64
+
+    // The size of clusters in m. For ThomasPlacement this is the standard deviation of the distance to the cluster center,
+    // for MaternPlacement it is the radius of the cluster.
+    ClusterRadius float64
+
+    // The distance between access points in m for GridPlacement. When set to 0, the spacing follows from the access point density.
+    GridSpacing float64
+
+    // The standard deviation in m of the offset of access points from their grid location for GridPlacement
+    GridJitter float64
+
+    // The path to a png image whose brightness sets the relative access point density for RasterPlacement.
+    // The image is stretched over the map.
+    DensityRaster string
+
+    // The standard deviation in dB of the transmit power of access points around 20 dBm
+    TransmitPowerDeviation float64
+
+    // The maximum antenna gain in dBi. Every access point gets a gain between 0 and this value.
+    AntennaGain float64
+
+    // The fraction of access points with a directional antenna, expressed between 0 and 1
+    DirectionalRate float64
+
+    // The 3 dB beamwidth of directional antennas in degrees. When set to 0, a beamwidth of 60 degrees is used.
+    Beamwidth float64
+
+    // The fraction of access points on the 5 GHz and 6 GHz bands, expressed between 0 and 1. The remaining access points use 2.4 GHz.
+    Band5Rate float64
+    Band6Rate float64
+
+    // The standard deviation in dB of the shadow fading of access points. Shadow fading is a persistent, location dependent
+    // bias of the signal strength, on top of which the noise of every reading is added. When set to 0, there is no shadow fading.
+    ShadowingDeviation float64
+
+    // The distance in m over which shadow fading decorrelates. When set to 0, a distance of 20 meters is used.
+    DecorrelationDistance float64
+
+    // The standard deviation in dB of noise that is correlated over time. When set to 0, there is no temporal noise.
+    TemporalDeviation float64
+
+    // The time in seconds over which temporal noise decorrelates. When set to 0, a coherence time of 10 seconds is used.
+    CoherenceTime float64
+
+    // The expected number of body shadowing events per second. During an event, the body of the user attenuates
+    // the access points behind it. When set to 0, there is no body shadowing.
+    BodyShadowingRate float64
+
+    // The attenuation in dB of body shadowing. When set to 0, an attenuation of 10 dB is used.
+    BodyShadowingLoss float64
+
+    // The mean duration of body shadowing events in seconds. When set to 0, events last 3 seconds on average.
+    BodyShadowingDuration float64
+
+    // The simulated time in seconds between two readings. When set to 0, readings are 1 second apart.
+    ScanInterval float64
+
     // The distance between seed readings in m
     SeedDistance float64
 
     // The distance between test readings in m
     TestDistance float64
 
+    // The distance from the edge of the map in m within which no tests are performed, to ensure access points can be found in all directions.
+    // When set to 0, a margin of 85 meters is used.
+    EdgeMargin float64
+
+    // The regions of the map for which results are evaluated and plotted separately, in addition to the full map.
+    // When no regions are set, a Center region of at most 500 x 500 m in the middle of the map is used.
+    Regions []*Region
+
+    // The device that performs the seed readings. When set to nil, an ideal receiver is used.
+    SeedDevice *Device
+
+    // The devices that perform the test readings, which take turns over the test locations. Results are evaluated
+    // and plotted separately for every device when there is more than one. When no devices are set, the seed device is used.
+    TestDevices []*Device
+
     // The number of test cycles to execute in this test
     TestCycles int
 
+    // The number of test locations per anchored cycle whose real location is fed to the algorithms after they are read,
+    // like a sparse survey that re-anchors learning algorithms. The locations are fed to every algorithm, so algorithms
+    // that do not learn from reads are updated as well. When set to 0, no cycles are anchored.
+    AnchorCount int
+
+    // The number of cycles between anchored cycles. When set to 0, every cycle is anchored.
+    AnchorInterval int
+
+    // The number of rogue access points, which broadcast the ID of a random access point from a random location.
+    // They appear after the seed readings.
+    RogueAccessPoints int
+
+    // The number of mobile hotspots, which move to a random location before every cycle. They appear after the seed readings.
+    MobileHotspots int
+
+    // The number of poisoned readings attackers feed to the algorithms after every cycle, and the distance in m between
+    // the location they are read at and the location they are fed with. When the distance is set to 0, 100 m is used.
+    // Poisoned readings are fed to every algorithm, so algorithms that do not learn from reads are poisoned as well.
+    PoisonedReadings int
+    PoisonDistance float64
+
     // The amount of access points to be replaced after every test cycle, expressed between 0 and 1
     ReplacementRate float64
 
-    // The strategy to use when replacing access points. Can be FiFoReplacement or RandomReplacement
+    // The strategy to use when replacing access points. Can be FiFoReplacement, RandomReplacement, RelocationReplacement,
+    // RegionalReplacement, PoissonReplacement or OutageReplacement
     ReplacementStrategy int
 
-    // The random seed to use when generating test readings. When set to 0, a random value is generated and used instead.
+    // The expected number of access points added and removed by PoissonReplacement after every test cycle, expressed as a
+    // fraction of the current number of access points
+    ArrivalRate float64
+    DepartureRate float64
+
+    // The number of cycles access points are switched off by OutageReplacement. When set to 0, outages last 1 cycle.
+    OutageDuration int
+
+    // The distance in m over which access points are moved by RelocationReplacement
+    RelocationDistance float64
+
+    // The distribution of the distances over which access points are moved. Can be FixedRelocation, UniformRelocation or ExponentialRelocation.
+    // When set to 0, FixedRelocation is used.
+    RelocationDistribution int
+
+    // The random seed for the simulation. The map layout, access point replacement, signal noise, signal reception and test order
+    // each draw from their own stream derived from this seed. When set to 0, a random value is generated and used instead.
     RandomSeed int64
 
     // The directory to save graphs and images
     OutputDir string
+
+    // The number of goroutines used to run the algorithms. When set to 0 or 1, everything runs serially.
+    // Results are identical regardless of the number of workers.
+    Workers int
 }
 
 // Values for Replacementstrategy configuration
 const (
     FiFoReplacement = 1
     RandomReplacement = 2
+    // Moves access points instead of replacing them, so they keep their ID
+    RelocationReplacement = 3
+    // Replaces the access points closest to a random location, with new access points in the same area
+    RegionalReplacement = 4
+    // Adds and removes random numbers of access points drawn from Poisson distributions with the arrival and departure rates
+    PoissonReplacement = 5
+    // Switches access points off for the outage duration, after which they return with the same ID
+    OutageReplacement = 6
 )
 
+// Values for Placement configuration
+const (
+    UniformPlacement = 1
+    ThomasPlacement = 2
+    MaternPlacement = 3
+    GridPlacement = 4
+    RasterPlacement = 5
+)
+
+// Values for RelocationDistribution configuration
+const (
+    // Every access point is moved exactly the relocation distance
+    FixedRelocation = 1
+    // Access points are moved a distance between 0 and the relocation distance
+    UniformRelocation = 2
+    // Access points are moved a distance with the relocation distance as mean
+    ExponentialRelocation = 3
+)
+
+const defaultEdgeMargin = 85.0
+const defaultScanInterval = 1.0
+const defaultBeamwidth = 60.0
+const defaultFrontToBack = 20.0
+const defaultPoisonDistance = 100.0
+
 func NewConfiguration() *Configuration {
     return &Configuration{}
 }
 
+// Adds a region for which results are evaluated separately
+func (config *Configuration) AddRegion(region *Region) {
+    config.Regions = append(config.Regions, region)
+}
+
+// Adds a device that performs test readings
+func (config *Configuration) AddTestDevice(device *Device) {
+    config.TestDevices = append(config.TestDevices, device)
+}
+
+// Returns the configured test devices, or the seed device if none are configured
+func (config *Configuration) testDevices() []*Device {
+    if len(config.TestDevices) > 0 {
+        return config.TestDevices
+    }
+    return []*Device{config.SeedDevice}
+}
+
+// Returns the number of access points initially placed on the map
+func (config *Configuration) accessPointCount() int {
+    return int(config.MapWidth * config.MapHeight) * config.AccessPointDensity / 1000000
+}
+
+// Returns the distance between grid locations for GridPlacement
+func (config *Configuration) gridSpacing() float64 {
+    if config.GridSpacing == 0 {
+        return math.Sqrt(1000000 / float64(config.AccessPointDensity))
+    }
+    return config.GridSpacing
+}
+
+func (config *Configuration) edgeMargin() float64 {
+    if config.EdgeMargin == 0 {
+        return defaultEdgeMargin
+    }
+    return config.EdgeMargin
+}
+
+func (config *Configuration) decorrelationDistance() float64 {
+    if config.DecorrelationDistance == 0 {
+        return defaultDecorrelationDistance
+    }
+    return config.DecorrelationDistance
+}
+
+func (config *Configuration) coherenceTime() float64 {
+    if config.CoherenceTime == 0 {
+        return defaultCoherenceTime
+    }
+    return config.CoherenceTime
+}
+
+func (config *Configuration) bodyShadowingLoss() float64 {
+    if config.BodyShadowingLoss == 0 {
+        return defaultBodyShadowingLoss
+    }
+    return config.BodyShadowingLoss
+}
+
+func (config *Configuration) bodyShadowingDuration() float64 {
+    if config.BodyShadowingDuration == 0 {
+        return defaultBodyShadowingDuration
+    }
+    return config.BodyShadowingDuration
+}
+
+func (config *Configuration) scanInterval() float64 {
+    if config.ScanInterval == 0 {
+        return defaultScanInterval
+    }
+    return config.ScanInterval
+}
+
+func (config *Configuration) beamwidth() float64 {
+    if config.Beamwidth == 0 {
+        return defaultBeamwidth
+    }
+    return config.Beamwidth
+}
+
+func (config *Configuration) poisonDistance() float64 {
+    if config.PoisonDistance == 0 {
+        return defaultPoisonDistance
+    }
+    return config.PoisonDistance
+}
+
+// Returns the number of test locations to anchor in the given cycle
+func (config *Configuration) anchors(cycle int) int {
+    if config.AnchorInterval > 1 && cycle % config.AnchorInterval != 0 {
+        return 0
+    }
+    return config.AnchorCount
+}
+
+// Returns whether access points get different radios, or all use the default radio
+func (config *Configuration) heterogeneousRadios() bool {
+    return config.TransmitPowerDeviation != 0 || config.AntennaGain != 0 || config.DirectionalRate != 0 ||
+        config.Band5Rate != 0 || config.Band6Rate != 0
+}
+
+// Returns the region in which tests are performed
+func (config *Configuration) testRegion() *Region {
+    margin := config.edgeMargin()
+    return NewRectangleRegion("Full", margin, margin, config.MapWidth - margin, config.MapHeight - margin)
+}
+
+// Returns the configured regions, or the default Center region if none are configured
+func (config *Configuration) regions() []*Region {
+    if len(config.Regions) > 0 {
+        return config.Regions
+    }
+    margin := config.edgeMargin()
+    return []*Region{NewRectangleRegion("Center",
+        math.Max(margin, config.MapWidth / 2 - 250),
+        math.Max(margin, config.MapHeight / 2 - 250),
+        math.Min(config.MapWidth - margin, config.MapWidth / 2 + 250),
+        math.Min(config.MapHeight - margin, config.MapHeight / 2 + 250))}
+}
+
 func (config *Configuration) validate() {
     if config.ReplacementRate != 0 && config.ReplacementStrategy == 0 {
         err_string := "** err: Replacement Rate set without a Replacement Strategy"
         panic(err_string)
     }
+    if config.ReplacementStrategy < 0 || config.ReplacementStrategy > OutageReplacement {
+        err_string := "** err: Unknown Replacement Strategy"
+        panic(err_string)
+    }
+    if (config.ArrivalRate != 0 || config.DepartureRate != 0) && config.ReplacementStrategy != PoissonReplacement {
+        err_string := "** err: Arrival and Departure Rates are only used by Poisson Replacement"
+        panic(err_string)
+    }
+    if config.ArrivalRate < 0 || config.DepartureRate < 0 {
+        err_string := "** err: Arrival and Departure Rates cannot be negative"
+        panic(err_string)
+    }
+    if config.OutageDuration < 0 {
+        err_string := "** err: Outage Duration cannot be negative"
+        panic(err_string)
+    }
+    if config.ReplacementStrategy == RelocationReplacement && config.RelocationDistance <= 0 {
+        err_string := "** err: Relocation Replacement requires a positive Relocation Distance"
+        panic(err_string)
+    }
+    if config.RelocationDistribution < 0 || config.RelocationDistribution > ExponentialRelocation {
+        err_string := "** err: Unknown Relocation Distribution"
+        panic(err_string)
+    }
+    if config.TransmitPowerDeviation < 0 || config.AntennaGain < 0 {
+        err_string := "** err: Transmit Power Deviation and Antenna Gain cannot be negative"
+        panic(err_string)
+    }
+    if config.DirectionalRate < 0 || config.DirectionalRate > 1 {
+        err_string := "** err: Directional Rate must be between 0 and 1"
+        panic(err_string)
+    }
+    if config.Beamwidth < 0 || config.Beamwidth > 360 {
+        err_string := "** err: Beamwidth must be between 0 and 360 degrees"
+        panic(err_string)
+    }
+    if config.Band5Rate < 0 || config.Band6Rate < 0 || config.Band5Rate + config.Band6Rate > 1 {
+        err_string := "** err: Band Rates must be positive and add up to at most 1"
+        panic(err_string)
+    }
+    if config.ShadowingDeviation < 0 || config.DecorrelationDistance < 0 {
+        err_string := "** err: Shadowing Deviation and Decorrelation Distance cannot be negative"
+        panic(err_string)
+    }
+    if config.TemporalDeviation < 0 || config.CoherenceTime < 0 {
+        err_string := "** err: Temporal Deviation and Coherence Time cannot be negative"
+        panic(err_string)
+    }
+    if config.BodyShadowingRate < 0 || config.BodyShadowingLoss < 0 || config.BodyShadowingDuration < 0 {
+        err_string := "** err: Body Shadowing Rate, Loss and Duration cannot be negative"
+        panic(err_string)
+    }
+    if config.ScanInterval < 0 {
+        err_string := "** err: Scan Interval cannot be negative"
+        panic(err_string)
+    }
     if config.AccessPointDensity == 0 {
         err_string := "** err: Access Point Density cannot be 0"
         panic(err_string)
@@ -60,10 +394,92 @@ func (config *Configuration) validate() {
         err_string := "** err: Map Height cannot be less than 160 meters"
         panic(err_string)
     }
+    if config.Placement < 0 || config.Placement > RasterPlacement {
+        err_string := "** err: Unknown Placement"
+        panic(err_string)
+    }
+    if (config.Placement == ThomasPlacement || config.Placement == MaternPlacement) && (config.ClusterSize <= 0 || config.ClusterRadius <= 0) {
+        err_string := "** err: Cluster Placement requires a positive Cluster Size and Cluster Radius"
+        panic(err_string)
+    }
+    if config.Placement == GridPlacement && (config.GridSpacing < 0 || config.GridJitter < 0) {
+        err_string := "** err: Grid Spacing and Grid Jitter cannot be negative"
+        panic(err_string)
+    }
+    if config.Placement == GridPlacement && (config.gridSpacing() > config.MapWidth || config.gridSpacing() > config.MapHeight) {
+        err_string := "** err: Grid Spacing cannot be larger than the Map Width or Map Height"
+        panic(err_string)
+    }
+    if config.Placement == RasterPlacement && config.DensityRaster == "" {
+        err_string := "** err: Raster Placement requires a Density Raster"
+        panic(err_string)
+    }
+    if config.EdgeMargin < 0 {
+        err_string := "** err: Edge Margin cannot be negative"
+        panic(err_string)
+    }
+    if config.MapWidth <= 2 * config.edgeMargin() || config.MapHeight <= 2 * config.edgeMargin() {
+        err_string := "** err: Map Width and Height must be more than twice the Edge Margin"
+        panic(err_string)
+    }
+    names := map[string]bool{"Full": true}
+    for _, region := range config.Regions {
+        if names[region.Name] {
+            err_string := fmt.Sprintf("** err: Region name %q is used more than once", region.Name)
+            panic(err_string)
+        }
+        names[region.Name] = true
+        if len(region.Vertices) < 3 {
+            err_string := fmt.Sprintf("** err: Region %q needs at least 3 vertices", region.Name)
+            panic(err_string)
+        }
+        // A region without width or height has no area either
+        if region.area() == 0 {
+            err_string := fmt.Sprintf("** err: Region %q has no area", region.Name)
+            panic(err_string)
+        }
+    }
+    devices := make(map[string]bool)
+    for _, device := range append([]*Device{config.SeedDevice}, config.TestDevices...) {
+        if device == nil {
+            continue
+        }
+        if device.Gain < 0 || device.Quantization < 0 || device.MaxAccessPoints < 0 {
+            err_string := fmt.Sprintf("** err: Gain, Quantization and Max Access Points of Device %q cannot be negative", device.Name)
+            panic(err_string)
+        }
+        if device.ScanLoss < 0 || device.ScanLoss >= 1 {
+            err_string := fmt.Sprintf("** err: Scan Loss of Device %q must be at least 0 and less than 1", device.Name)
+            panic(err_string)
+        }
+    }
+    for _, device := range config.TestDevices {
+        if device == nil {
+            err_string := "** err: Test Devices cannot be nil"
+            panic(err_string)
+        }
+        if devices[device.Name] {
+            err_string := fmt.Sprintf("** err: Device name %q is used more than once", device.Name)
+            panic(err_string)
+        }
+        devices[device.Name] = true
+    }
     if config.SeedDistance == 0 {
         err_string := "** err: Seed Distance cannot be 0"
         panic(err_string)
     }
+    if config.AnchorCount < 0 || config.AnchorInterval < 0 {
+        err_string := "** err: Anchor Count and Anchor Interval cannot be negative"
+        panic(err_string)
+    }
+    if config.RogueAccessPoints < 0 || config.MobileHotspots < 0 || config.PoisonedReadings < 0 || config.PoisonDistance < 0 {
+        err_string := "** err: Rogue Access Points, Mobile Hotspots, Poisoned Readings and Poison Distance cannot be negative"
+        panic(err_string)
+    }
+    if config.Workers < 0 {
+        err_string := "** err: Workers cannot be negative"
+        panic(err_string)
+    }
     if config.TestDistance == 0 {
         err_string := "** err: Test Distance cannot be 0"
         panic(err_string)
@@ -0,0 +1,82 @@
+package wifi
+
+import (
+    "math"
+    "math/rand"
+    "sort"
+)
+
+////////////
+// Device //
+////////////
+
+// A Device is the profile of a receiver, describing how it reports the signals it receives
+type Device struct {
+    Name string
+    // The reported signal strength is Gain * signal strength + Offset, in dB. When the gain is 0, a gain of 1 is used.
+    Gain, Offset float64
+    // Signals reported weaker than the sensitivity in dBm are dropped. When set to 0, no signals are dropped.
+    Sensitivity float64
+    // The maximum number of access points in a scan, which keeps the strongest. When set to 0, all access points are reported.
+    MaxAccessPoints int
+    // The step in dB to which reported signal strengths are rounded. When set to 0, signal strengths are not rounded.
+    Quantization float64
+    // The probability that a received signal is missing from a scan, expressed between 0 and 1
+    ScanLoss float64
+}
+
+// Returns a device that reports every signal exactly as it is received
+func NewDevice(name string) *Device {
+    return &Device{Name: name, Gain: 1}
+}
+
+func (d *Device) gain() float64 {
+    if d.Gain == 0 {
+        return 1
+    }
+    return d.Gain
+}
+
+// Returns the gain and offset of the device. The ideal receiver, a nil device, has a gain of 1 and no offset.
+func (d *Device) response() (gain, offset float64) {
+    if d == nil {
+        return 1, 0
+    }
+    return d.gain(), d.Offset
+}
+
+// Returns the name of the device, which identifies it as a receiver. The ideal receiver has no name.
+func (d *Device) receiver() string {
+    if d == nil {
+        return ""
+    }
+    return d.Name
+}
+
+// Returns the signals as they are reported by the device. The ideal receiver, a nil device, reports them unchanged.
+// Scan losses are drawn from the given random stream.
+func (d *Device) report(random *rand.Rand, signals Signals) Signals {
+    if d == nil {
+        return signals
+    }
+    reported := signals[:0]
+    for _, signal := range signals {
+        signal.signalStrength = d.gain() * signal.signalStrength + d.Offset
+        if d.Quantization != 0 {
+            signal.signalStrength = math.Floor(signal.signalStrength / d.Quantization + 0.5) * d.Quantization
+        }
+        if d.Sensitivity != 0 && signal.signalStrength < d.Sensitivity {
+            continue
+        }
+        if d.ScanLoss != 0 && random.Float64() < d.ScanLoss {
+            continue
+        }
+        reported = append(reported, signal)
+    }
+    if d.MaxAccessPoints != 0 && len(reported) > d.MaxAccessPoints {
+        sort.Sort(BySignalStrength(reported))
+        reported = reported[:d.MaxAccessPoints]
+        sort.Sort(ByID(reported))
+    }
+    return reported
+}
@@ -1,23 +1,146 @@
 package wifi
 
 import (
-    "math/rand"
     "fmt"
     "github.com/ruphin/go-gnuplot/pkg/gnuplot"
+    "image"
+    "image/color"
+    "image/draw"
+    "image/png"
+    "math"
+    "math/rand"
     "os"
+    "path/filepath"
+    "sort"
     "strings"
+    "sync"
+    "time"
 )
 
 type algorithm interface {
     feed(signals Signals, location *Location)
-    read(signals Signals, location *Location) (*Location, bool)
+    read(signals Signals, location *Location) (*Estimate, bool)
+    // Returns true if read modifies the algorithm, in which case locations must be read one at a time in order
+    sequential() bool
+}
+
+// An AccessPointEstimate is the location an algorithm has learned for an access point,
+// along with the number of samples that location is based on.
+type AccessPointEstimate struct {
+    ID int
+    Location *Location
+    Samples int
+}
+
+// Sorting helpers
+type ByAccessPointID []AccessPointEstimate
+func (estimates ByAccessPointID) Len() int           { return len(estimates) }
+func (estimates ByAccessPointID) Swap(i, j int)      { estimates[i], estimates[j] = estimates[j], estimates[i] }
+func (estimates ByAccessPointID) Less(i, j int) bool { return estimates[i].ID < estimates[j].ID }
+
+// Algorithms that learn access point locations implement accessPointEstimator
+type accessPointEstimator interface {
+    accessPointEstimates() []AccessPointEstimate
+}
+
+// Algorithms that keep a database of observations implement databaseSizer
+type databaseSizer interface {
+    databaseSize() int
+}
+
+// Algorithms that take the device of a reading into account implement deviceAlgorithm
+type deviceAlgorithm interface {
+    feedDevice(signals Signals, location *Location, device *Device)
+    readDevice(signals Signals, location *Location, device *Device) (*Estimate, bool)
+}
+
+// Algorithms that estimate how devices report signals implement deviceCalibrator.
+// A device reports slope * signal strength + offset, relative to the device the algorithm is fed with.
+type deviceCalibrator interface {
+    deviceCalibration(receiver string) (slope, offset float64)
+}
+
+// Algorithms that wrap another algorithm implement wrapper
+type wrapper interface {
+    unwrap() algorithm
+}
+
+// Algorithms that act once all seed readings are fed implement seedFinisher
+type seedFinisher interface {
+    seeded()
+}
+
+// Feeds the algorithm a reading by the given device
+func feedDevice(a algorithm, signals Signals, location *Location, device *Device) {
+    if d, ok := a.(deviceAlgorithm); ok {
+        d.feedDevice(signals, location, device)
+        return
+    }
+    a.feed(signals, location)
+}
+
+// Reads a reading by the given device with the algorithm
+func readDevice(a algorithm, signals Signals, location *Location, device *Device) (*Estimate, bool) {
+    if d, ok := a.(deviceAlgorithm); ok {
+        return d.readDevice(signals, location, device)
+    }
+    return a.read(signals, location)
+}
+
+// Returns the access point locations learned by the given algorithm, or by the algorithm it wraps.
+// The second return value is false if the algorithm does not learn access point locations.
+func AccessPointEstimates(a algorithm) ([]AccessPointEstimate, bool) {
+    for {
+        if estimator, ok := a.(accessPointEstimator); ok {
+            return estimator.accessPointEstimates(), true
+        }
+        w, ok := a.(wrapper)
+        if !ok {
+            return nil, false
+        }
+        a = w.unwrap()
+    }
 }
 
+// Tells the given algorithm, or the algorithm it wraps, that all seed readings are fed
+func seeded(a algorithm) {
+    for {
+        if finisher, ok := a.(seedFinisher); ok {
+            finisher.seeded()
+            return
+        }
+        w, ok := a.(wrapper)
+        if !ok {
+            return
+        }
+        a = w.unwrap()
+    }
+}
+
+// Returns the database size of the given algorithm, or of the algorithm it wraps.
+// The second return value is false if the algorithm does not keep a database.
+func databaseSize(a algorithm) (int, bool) {
+    for {
+        if sizer, ok := a.(databaseSizer); ok {
+            return sizer.databaseSize(), true
+        }
+        w, ok := a.(wrapper)
+        if !ok {
+            return 0, false
+        }
+        a = w.unwrap()
+    }
+}
+
+// An engine owns its map, algorithms and random streams and shares no state with other engines,
+// so independent simulations can run concurrently in one process.
 type engine struct {
     m *Map
     algorithms map[string]algorithm
     accessPointGenerations []int
     config *Configuration
+    random *randomStreams
+    placement placement
 }
 
 // Create a new Engine from the given configuration.
@@ -25,60 +148,148 @@ func NewEngine(config *Configuration) *engine {
 
     config.validate()
 
-    engineMap := NewMap(config.MapWidth, config.MapHeight, config.RandomSeed)
-    engine := &engine{engineMap, make(map[string]algorithm), make([]int, 0), config}
+    seed := config.RandomSeed
+    if seed == 0 {
+        seed = time.Now().UTC().UnixNano()
+    }
+    fmt.Printf("Using random seed %d\n", seed)
+    random := newRandomStreams(seed)
+
+    engineMap := NewMap(config.MapWidth, config.MapHeight, random.noise, random.dropout)
+    engineMap.SetDeviceRandom(random.devices)
+    if config.ShadowingDeviation > 0 {
+        engineMap.EnableShadowing(random.shadowing, config.ShadowingDeviation, config.decorrelationDistance())
+    }
+    if config.TemporalDeviation > 0 {
+        engineMap.EnableTemporalNoise(random.temporal, config.TemporalDeviation, config.coherenceTime())
+    }
+    if config.BodyShadowingRate > 0 {
+        engineMap.EnableBodyShadowing(random.body, config.BodyShadowingRate, config.bodyShadowingLoss(), config.bodyShadowingDuration())
+    }
+    engine := &engine{engineMap, make(map[string]algorithm), make([]int, 0), config, random, newPlacement(config, random.layout)}
 
-    accessPointCount := int(config.MapWidth * config.MapHeight) * config.AccessPointDensity / 1000000
-    for i := 0; i < accessPointCount; i++ {
-        engine.m.AddRandomAccessPoint()
+    for i := 0; i < config.accessPointCount(); i++ {
+        engine.addAccessPoint(random.layout, engine.placement.location(random.layout))
     }
 
-    engine.accessPointGenerations = append(engine.accessPointGenerations, accessPointCount)
-    engine.m.Draw(engine.accessPointGenerations)
+    engine.accessPointGenerations = append(engine.accessPointGenerations, engine.m.lastID)
+    engine.m.Draw(engine.mapDirectory(), engine.accessPointGenerations)
     return engine
 }
 
+// Adds an access point at the given location with a radio drawn from the given random stream
+func (e *engine) addAccessPoint(random *rand.Rand, location *Location) int {
+    id := e.m.AddAccessPoint(location)
+    e.setRadio(random, id)
+    return id
+}
+
+// Gives the access point with the given ID a radio drawn from the given random stream.
+// Nothing is drawn when all access points use the default radio, so the random streams are unaffected.
+func (e *engine) setRadio(random *rand.Rand, id int) {
+    if !e.config.heterogeneousRadios() {
+        return
+    }
+    radio := DefaultRadio()
+    radio.TransmitPower += random.NormFloat64() * e.config.TransmitPowerDeviation
+    radio.AntennaGain = random.Float64() * e.config.AntennaGain
+    if random.Float64() < e.config.DirectionalRate {
+        radio.Azimuth = random.Float64() * 2 * math.Pi
+        radio.Beamwidth = e.config.beamwidth() * math.Pi / 180
+        radio.FrontToBack = defaultFrontToBack
+    }
+    band := random.Float64()
+    if band < e.config.Band5Rate {
+        radio.Band = Band5
+    } else if band < e.config.Band5Rate + e.config.Band6Rate {
+        radio.Band = Band6
+    }
+    e.m.SetRadio(id, radio)
+}
+
+// Map images are saved in a subdirectory of the output directory, so engines with different output directories do not overwrite each other's maps
+func (e *engine) mapDirectory() string {
+    return filepath.Join(e.config.OutputDir, "maps")
+}
+
 func (e *engine) AddAlgorithm(name string, algorithm algorithm) {
     e.algorithms[name] = algorithm
 }
 
+// Returns the names of the algorithms in sorted order, so graphs and output are the same for every run
+func (e *engine) algorithmNames() []string {
+    names := make([]string, 0, len(e.algorithms))
+    for name, _ := range e.algorithms {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
 func (e *engine) Run() {
     var testCycles = e.config.TestCycles
-    var mapWidth = e.config.MapWidth
-    var mapHeight = e.config.MapHeight
-    var testMinWidth = mapWidth / 2 - 250.00
-    var testMaxWidth = mapWidth / 2 + 250.00
-    var testMinHeight = mapHeight / 2 - 250.00
-    var testMaxHeight = mapHeight / 2 + 250.00
     var testDistance = e.config.TestDistance
+    var testRegion = e.config.testRegion()
+    var regions = append([]*Region{testRegion}, e.config.regions()...)
 
     e.seed()
+    e.addAdversaries()
 
     // Initialize testing locations.
     // Locations are tested on a grid with specified testing distance.
-    // No tests are performed within 75 meters of the edge of the map, to ensure access points can be found in all directions
+    // No tests are performed within the edge margin of the map, to ensure access points can be found in all directions
     var locations []*Location
-    for x := 85.0; x <= float64(mapWidth) - 85; x += testDistance {
-        for y := 85.0; y <= float64(mapHeight) - 85; y += testDistance {
+    min, max := testRegion.bounds()
+    for x := min.X; x <= max.X; x += testDistance {
+        for y := min.Y; y <= max.Y; y += testDistance {
             locations =  append(locations, NewLocation(x,y))
         }
     }
 
-    // Maps to record cumulative Error and miss counts per algorithm per cycle
-    algorithmErrors := make(map[string][][]float64)
-    algorithmMisses := make(map[string][]float64)
-    centerAlgorithmErrors := make(map[string][][]float64)
-    centerAlgorithmMisses := make(map[string][]float64)
-    for name, _ := range e.algorithms {
-        algorithmErrors[name] = make([][]float64, testCycles + 1)
-        algorithmMisses[name] = make([]float64, testCycles + 1)
-        centerAlgorithmErrors[name] = make([][]float64, testCycles + 1)
-        centerAlgorithmMisses[name] = make([]float64, testCycles + 1)
+    // The regions every location belongs to
+    inRegion := make(map[*Location][]bool)
+    for _, location := range locations {
+        inRegion[location] = make([]bool, len(regions))
+        for i, region := range regions {
+            inRegion[location][i] = region.contains(location)
+        }
     }
 
-    var signals Signals
-    var success bool
-    var estimatedLocation *Location
+    // Maps to record cumulative Error and miss counts per region per algorithm per cycle
+    regionErrors := make([]map[string][][]float64, len(regions))
+    regionMisses := make([]map[string][]float64, len(regions))
+    for i, _ := range regions {
+        regionErrors[i] = make(map[string][][]float64)
+        regionMisses[i] = make(map[string][]float64)
+        for name, _ := range e.algorithms {
+            for _, device := range e.config.testDevices() {
+                regionErrors[i][e.resultName(name, device)] = make([][]float64, testCycles + 1)
+                regionMisses[i][e.resultName(name, device)] = make([]float64, testCycles + 1)
+            }
+        }
+    }
+
+    // Average distance between learned and real access point locations per algorithm per cycle
+    accessPointErrors := make(map[string][]float64)
+
+    // Number of entries in the database of every algorithm that keeps one, per cycle
+    databaseSizes := make(map[string][]float64)
+
+    // Average error of the device calibrations of every algorithm that calibrates devices, per cycle
+    calibrationErrors := make(map[string][]float64)
+
+    // Percentage of estimates in the test region with the real location within the estimated radius, per algorithm per cycle
+    radiusCoverage := make(map[string][]float64)
+
+    // Percentage of the signals of rogue access points, of mobile hotspots and of the other access points that were
+    // rejected as outliers, per algorithm per cycle. Only recorded when there are rogue access points or mobile hotspots.
+    rogueDetection := make(map[string][]float64)
+    hotspotDetection := make(map[string][]float64)
+    falseAlarms := make(map[string][]float64)
+
+    var results map[string][]result
+    var readings []Signals
+    var outcome result
 
     fmt.Printf("Starting simulation\n")
     fmt.Printf("Performing %d localizations in each of %d cycles\n\n", len(locations), testCycles)
@@ -89,41 +300,139 @@ func (e *engine) Run() {
         // Before every cycle except the first, replace access points
         if cycle != 0 {
             e.replaceAccessPoints()
+            e.m.MoveMobileHotspots(e.random.adversary)
         }
 
         // Randomize the order of testing locations
         for i := range locations {
-            j := rand.Intn(i + 1)
+            j := e.random.shuffle.Intn(i + 1)
             locations[i], locations[j] = locations[j], locations[i]
         }
 
         // For every location, test each algorithm
-        for _, location := range locations {
-            signals = e.m.Read(location)
-            for name, algorithm := range e.algorithms {
-                estimatedLocation, success = algorithm.read(signals, location)
-                if location.X >= testMinWidth && location.X <= testMaxWidth && location.Y >= testMinHeight && location.Y <= testMaxHeight {
-                    if success {
-                        centerAlgorithmErrors[name][cycle] =  append(centerAlgorithmErrors[name][cycle], distance(location, estimatedLocation))
-                    } else {
-                        centerAlgorithmMisses[name][cycle] += 1
+        results, readings = e.readAll(locations, e.config.anchors(cycle))
+        e.poison()
+        for name, _ := range e.algorithms {
+            var covered, estimates float64
+            for i, location := range locations {
+                outcome = results[name][i]
+                resultName := e.resultName(name, e.testDevice(i))
+                if outcome.success {
+                    estimates += 1
+                    if distance(location, outcome.estimate.Location) <= outcome.estimate.Radius {
+                        covered += 1
                     }
                 }
-                if success {
-                    algorithmErrors[name][cycle] =  append(algorithmErrors[name][cycle], distance(location, estimatedLocation))
-                } else {
-                    algorithmMisses[name][cycle] += 1
+                for r, _ := range regions {
+                    if !inRegion[location][r] {
+                        continue
+                    }
+                    if outcome.success {
+                        regionErrors[r][resultName][cycle] =  append(regionErrors[r][resultName][cycle], distance(location, outcome.estimate.Location))
+                    } else {
+                        regionMisses[r][resultName][cycle] += 1
+                    }
                 }
             }
+            if estimates > 0 {
+                radiusCoverage[name] = append(radiusCoverage[name], covered / estimates * 100)
+            } else {
+                radiusCoverage[name] = append(radiusCoverage[name], math.NaN())
+            }
+            if len(e.m.clones) > 0 {
+                rogueDetection[name] = append(rogueDetection[name], rejectionRate(results[name], readings, rogueSource))
+            }
+            if len(e.m.hotspots) > 0 {
+                hotspotDetection[name] = append(hotspotDetection[name], rejectionRate(results[name], readings, hotspotSource))
+            }
+            if e.m.rogues() {
+                falseAlarms[name] = append(falseAlarms[name], rejectionRate(results[name], readings, accessPointSource))
+            }
+        }
+
+        for name, algorithm := range e.algorithms {
+            if estimates, ok := AccessPointEstimates(algorithm); ok {
+                accessPointErrors[name] = append(accessPointErrors[name], e.accessPointError(estimates))
+            }
+            if size, ok := databaseSize(algorithm); ok {
+                databaseSizes[name] = append(databaseSizes[name], float64(size))
+            }
+            if calibrator, ok := algorithm.(deviceCalibrator); ok {
+                calibrationErrors[name] = append(calibrationErrors[name], e.calibrationError(calibrator))
+            }
         }
         fmt.Printf("Completed tests for cycle %2d\n", cycle)
     }
 
     fmt.Printf("\nSimulation completed. Generating Graphs...\n")
-    e.plot(centerAlgorithmErrors, centerAlgorithmMisses, "Center")
-    e.plot(algorithmErrors, algorithmMisses, "Full")
-    e.drawLastFrame()
-    e.drawLastFrameCenter()
+    for r, region := range regions {
+        e.plot(regionErrors[r], regionMisses[r], region.Name)
+    }
+
+    // The full frame is drawn across the whole map, other regions are drawn with some space around them
+    mapMin, mapMax := NewLocation(0, 0), NewLocation(e.config.MapWidth, e.config.MapHeight)
+    e.drawLastFrame(testRegion, mapMin, mapMax)
+    for _, region := range regions[1:] {
+        min, max := region.bounds()
+        margin := math.Max(max.X - min.X, max.Y - min.Y) * 0.25
+        e.drawLastFrame(region, NewLocation(min.X - margin, min.Y - margin), NewLocation(max.X + margin, max.Y + margin))
+    }
+    if len(databaseSizes) > 0 {
+        e.plotPerCycle(databaseSizes, "Database Size", "database-sizes")
+    }
+    e.plotPerCycle(radiusCoverage, "Radius Coverage", "radius-coverage")
+    if len(calibrationErrors) > 0 {
+        e.plotPerCycle(calibrationErrors, "Calibration Error", "calibration-errors")
+    }
+    if len(rogueDetection) > 0 {
+        e.plotPerCycle(rogueDetection, "Rogue Detection Rate", "rogue-detection")
+    }
+    if len(hotspotDetection) > 0 {
+        e.plotPerCycle(hotspotDetection, "Hotspot Detection Rate", "hotspot-detection")
+    }
+    if len(falseAlarms) > 0 {
+        e.plotPerCycle(falseAlarms, "False Alarm Rate", "false-alarms")
+    }
+    if len(accessPointErrors) > 0 {
+        e.plotPerCycle(accessPointErrors, "Access Point Errors", "accesspoint-errors")
+        e.drawAccessPointEstimates()
+    }
+    if e.posteriorEstimators() {
+        e.drawPosteriors(testRegion)
+    }
+}
+
+// Returns the average error in dB of the calibrated signal strength of the test devices, at a typical signal strength.
+// Devices are calibrated relative to the seed device.
+func (e *engine) calibrationError(calibrator deviceCalibrator) float64 {
+    referenceGain, referenceOffset := e.config.SeedDevice.response()
+    var sum float64
+    for _, device := range e.config.testDevices() {
+        gain, offset := device.response()
+        trueSlope := gain / referenceGain
+        trueOffset := offset - trueSlope * referenceOffset
+        slope, estimatedOffset := calibrator.deviceCalibration(device.receiver())
+        sum += math.Abs(slope * calibrationSignalStrength + estimatedOffset - (trueSlope * calibrationSignalStrength + trueOffset))
+    }
+    return sum / float64(len(e.config.testDevices()))
+}
+
+// Returns the average distance between the estimated and real locations of the access points currently on the map.
+// Estimates for access points that are no longer on the map are ignored. Returns NaN if no access point on the map is estimated.
+func (e *engine) accessPointError(estimates []AccessPointEstimate) float64 {
+    locations := e.m.accessPointLocations()
+    var sum float64
+    var count int
+    for _, estimate := range estimates {
+        if location, exists := locations[estimate.ID]; exists {
+            sum += distance(location, estimate.Location)
+            count += 1
+        }
+    }
+    if count == 0 {
+        return math.NaN()
+    }
+    return sum / float64(count)
 }
 
 // Seed the Algorithms with initial data.
@@ -132,17 +441,226 @@ func (e *engine) seed() {
     mapHeight := e.config.MapHeight
     distance := e.config.SeedDistance
 
-    var location *Location
-    var signals Signals
+    // Read all signals before feeding, so the readings do not depend on how the algorithms are scheduled
+    var locations []*Location
+    var readings []Signals
     for x := 0.0; x <= mapWidth; x += distance {
         for y := 0.0; y <= mapHeight; y += distance {
-            location = NewLocation(x, y)
-            signals = e.m.Read(location)
-            for _, algorithm := range e.algorithms {
-                algorithm.feed(signals, location)
+            locations = append(locations, NewLocation(x, y))
+            readings = append(readings, e.read(locations[len(locations)-1], e.config.SeedDevice))
+        }
+    }
+
+    // Every algorithm is fed all readings in order in its own task
+    var tasks []func()
+    for _, algorithm := range e.algorithms {
+        algorithm := algorithm
+        tasks = append(tasks, func() {
+            for i, location := range locations {
+                feedDevice(algorithm, e.signals(readings[i]), location, e.config.SeedDevice)
+            }
+            seeded(algorithm)
+        })
+    }
+    e.parallel(tasks)
+}
+
+// Adds the configured rogue access points and mobile hotspots to the map at random locations
+func (e *engine) addAdversaries() {
+    for i := 0; i < e.config.RogueAccessPoints && len(e.m.accessPoints) > 0; i++ {
+        e.m.AddRandomRogueAccessPoint(e.random.adversary)
+    }
+    for i := 0; i < e.config.MobileHotspots; i++ {
+        e.m.AddMobileHotspot(NewRandomLocation(e.random.adversary, e.config.MapWidth, e.config.MapHeight))
+    }
+}
+
+// Feeds the configured number of poisoned readings to every algorithm, including those that do not learn from reads.
+// Every reading is read at a random location in the test region, and fed with a location the poison distance away
+// in a random direction, within the map.
+func (e *engine) poison() {
+    if e.config.PoisonedReadings == 0 {
+        return
+    }
+    region := e.config.testRegion()
+    locations := make([]*Location, e.config.PoisonedReadings)
+    readings := make([]Signals, e.config.PoisonedReadings)
+    for i, _ := range locations {
+        location := region.randomLocation(e.random.adversary)
+        readings[i] = e.read(location, e.testDevice(i))
+        angle := 2 * math.Pi * e.random.adversary.Float64()
+        locations[i] = NewLocation(
+            math.Max(0, math.Min(e.config.MapWidth, location.X + e.config.poisonDistance() * math.Cos(angle))),
+            math.Max(0, math.Min(e.config.MapHeight, location.Y + e.config.poisonDistance() * math.Sin(angle))))
+    }
+    e.feedAll(locations, readings)
+}
+
+// Returns the percentage of the signals from the given source that were rejected as outliers by the successful estimates.
+// Only signals the estimates report as inliers or outliers count, signals of access points an algorithm does not know
+// are ignored. Returns NaN when no signal counts.
+func rejectionRate(results []result, readings []Signals, source int) float64 {
+    var considered, rejected float64
+    for i, outcome := range results {
+        if !outcome.success {
+            continue
+        }
+        outliers := make(map[int]bool, len(outcome.estimate.Outliers))
+        for _, id := range outcome.estimate.Outliers {
+            outliers[id] = true
+        }
+        inliers := make(map[int]bool, len(outcome.estimate.Inliers))
+        for _, id := range outcome.estimate.Inliers {
+            inliers[id] = true
+        }
+        for _, signal := range readings[i] {
+            if signal.source != source {
+                continue
+            }
+            if outliers[signal.id] {
+                considered += 1
+                rejected += 1
+            } else if inliers[signal.id] {
+                considered += 1
             }
         }
     }
+    if considered == 0 {
+        return math.NaN()
+    }
+    return rejected / considered * 100
+}
+
+// Advances the simulated time by one scan interval, and reads the signals at the given location with the given device
+func (e *engine) read(location *Location, device *Device) Signals {
+    e.m.Advance(e.config.scanInterval())
+    return e.m.ReadDevice(location, device)
+}
+
+// Returns the device that reads the i-th test location
+func (e *engine) testDevice(i int) *Device {
+    devices := e.config.testDevices()
+    return devices[i % len(devices)]
+}
+
+// Returns the name under which results of the algorithm with the given device are recorded.
+// Results are only recorded per device when there is more than one test device.
+func (e *engine) resultName(name string, device *Device) string {
+    if len(e.config.testDevices()) > 1 {
+        return fmt.Sprintf("%v (%v)", name, device.Name)
+    }
+    return name
+}
+
+// The outcome of a single algorithm read
+type result struct {
+    estimate *Estimate
+    success bool
+}
+
+// Reads every location with every algorithm, and returns the results per algorithm in the order of the locations,
+// along with the readings.
+// Location i is read by test device testDevice(i).
+// Sequential algorithms read all locations in order in a single task.
+// Other algorithms split the locations in chunks that are read in separate tasks.
+// After all reads, the first anchors locations are fed to every algorithm with their real location.
+func (e *engine) readAll(locations []*Location, anchors int) (map[string][]result, []Signals) {
+    // Read all signals up front, so the readings do not depend on how the algorithms are scheduled
+    readings := make([]Signals, len(locations))
+    for i, location := range locations {
+        readings[i] = e.read(location, e.testDevice(i))
+    }
+
+    chunks := 1
+    if e.config.Workers > 1 {
+        chunks = e.config.Workers
+    }
+
+    results := make(map[string][]result)
+    var tasks []func()
+    for name, algorithm := range e.algorithms {
+        algorithm := algorithm
+        algorithmResults := make([]result, len(locations))
+        results[name] = algorithmResults
+
+        chunkSize := len(locations)
+        if !algorithm.sequential() {
+            chunkSize = (len(locations) + chunks - 1) / chunks
+        }
+        for start := 0; start < len(locations); start += chunkSize {
+            start := start
+            end := start + chunkSize
+            if end > len(locations) {
+                end = len(locations)
+            }
+            tasks = append(tasks, func() {
+                for i := start; i < end; i++ {
+                    algorithmResults[i].estimate, algorithmResults[i].success = readDevice(algorithm, e.signals(readings[i]), locations[i], e.testDevice(i))
+                }
+            })
+        }
+    }
+    e.parallel(tasks)
+
+    if anchors > len(locations) {
+        anchors = len(locations)
+    }
+    e.feedAll(locations[:anchors], readings[:anchors])
+    return results, readings
+}
+
+// Feeds every reading with its location to every algorithm, in order in a task per algorithm.
+// Reading i is fed as read by test device testDevice(i).
+func (e *engine) feedAll(locations []*Location, readings []Signals) {
+    if len(readings) == 0 {
+        return
+    }
+    var tasks []func()
+    for _, algorithm := range e.algorithms {
+        algorithm := algorithm
+        tasks = append(tasks, func() {
+            for i, location := range locations {
+                feedDevice(algorithm, e.signals(readings[i]), location, e.testDevice(i))
+            }
+        })
+    }
+    e.parallel(tasks)
+}
+
+// Runs the given tasks with at most the configured number of workers at a time.
+// When no workers are configured, the tasks are run serially in order.
+func (e *engine) parallel(tasks []func()) {
+    workers := e.config.Workers
+    if workers <= 1 {
+        for _, task := range tasks {
+            task()
+        }
+        return
+    }
+
+    var wg sync.WaitGroup
+    semaphore := make(chan bool, workers)
+    for _, task := range tasks {
+        wg.Add(1)
+        semaphore <- true
+        go func(task func()) {
+            defer wg.Done()
+            task()
+            <-semaphore
+        }(task)
+    }
+    wg.Wait()
+}
+
+// Returns the signals to pass to an algorithm.
+// Algorithms sort and store the signals they are given, so concurrent algorithms each receive their own copy.
+func (e *engine) signals(signals Signals) Signals {
+    if e.config.Workers <= 1 {
+        return signals
+    }
+    signalsCopy := make(Signals, len(signals))
+    copy(signalsCopy, signals)
+    return signalsCopy
 }
 
 // Replace accesspoints
@@ -152,22 +670,56 @@ func (e *engine) replaceAccessPoints() {
 
     replacementCount := int(float64(len(e.m.accessPoints)) * replacementRate)
 
-    for i := 0; i < replacementCount; i++ {
-        if replacementStrategy == FiFoReplacement {
-            e.m.RemoveOldestAccessPoint()
-        } else if replacementStrategy == RandomReplacement {
-            e.m.RemoveRandomAccessPoint()
-        } else {
-            err_string := "** err: Unknown Replacement Strategy"
-            panic(err_string)
+    switch replacementStrategy {
+    case 0:
+        // Access points are never replaced
+    case FiFoReplacement, RandomReplacement:
+        for i := 0; i < replacementCount; i++ {
+            if replacementStrategy == FiFoReplacement {
+                e.m.RemoveOldestAccessPoint()
+            } else {
+                e.m.RemoveRandomAccessPoint(e.random.replacement)
+            }
         }
+        for i := 0; i < replacementCount; i++ {
+            e.addAccessPoint(e.random.replacement, e.placement.location(e.random.replacement))
+        }
+    case RelocationReplacement:
+        for i := 0; i < replacementCount; i++ {
+            e.m.RelocateRandomAccessPoint(e.random.replacement, e.relocationDistance())
+        }
+    case RegionalReplacement:
+        // Re-equip the area around a random location with the same number of access points
+        center := NewRandomLocation(e.random.replacement, e.config.MapWidth, e.config.MapHeight)
+        radius := e.m.RemoveNearestAccessPoints(center, replacementCount)
+        for i := 0; i < replacementCount; i++ {
+            e.setRadio(e.random.replacement, e.m.AddAccessPointNear(e.random.replacement, center, radius))
+        }
+    case PoissonReplacement:
+        accessPointCount := float64(len(e.m.accessPoints))
+        departures := poisson(e.random.replacement, e.config.DepartureRate * accessPointCount)
+        arrivals := poisson(e.random.replacement, e.config.ArrivalRate * accessPointCount)
+        for i := 0; i < departures && len(e.m.accessPoints) > 0; i++ {
+            e.m.RemoveRandomAccessPoint(e.random.replacement)
+        }
+        for i := 0; i < arrivals; i++ {
+            e.addAccessPoint(e.random.replacement, e.placement.location(e.random.replacement))
+        }
+    case OutageReplacement:
+        duration := e.config.OutageDuration
+        if duration == 0 {
+            duration = 1
+        }
+        e.m.AdvanceOutages()
+        for i := 0; i < replacementCount; i++ {
+            e.m.SwitchOffRandomAccessPoint(e.random.replacement, duration)
+        }
+    default:
+        err_string := "** err: Unknown Replacement Strategy"
+        panic(err_string)
     }
 
-    for i := 0; i < replacementCount; i++ {
-        e.m.AddRandomAccessPoint()
-    }
-
-    e.accessPointGenerations = append(e.accessPointGenerations, e.m.accessPoints[len(e.m.accessPoints)-1].id)
+    e.accessPointGenerations = append(e.accessPointGenerations, e.m.lastID)
 
     // accessPointCounts := make([]int, len(e.accessPointGenerations))
     // for _, accessPoint := range e.m.accessPoints {
@@ -183,7 +735,20 @@ func (e *engine) replaceAccessPoints() {
     //
     // fmt.Printf("Access point generations: %v\n", e.accessPointGenerations)
 
-    e.m.Draw(e.accessPointGenerations)
+    e.m.Draw(e.mapDirectory(), e.accessPointGenerations)
+}
+
+// Returns a distance to relocate an access point over, drawn from the configured distribution
+func (e *engine) relocationDistance() float64 {
+    relocationDistance := e.config.RelocationDistance
+    switch e.config.RelocationDistribution {
+    case UniformRelocation:
+        return e.random.replacement.Float64() * relocationDistance
+    case ExponentialRelocation:
+        return e.random.replacement.ExpFloat64() * relocationDistance
+    default:
+        return relocationDistance
+    }
 }
 
 func (e *engine) plot(algorithmErrors map[string][][]float64, algorithmMisses map[string][]float64, plottype string) {
@@ -228,7 +793,12 @@ func (e *engine) plot(algorithmErrors map[string][][]float64, algorithmMisses ma
     var misses []float64
     var sum float64
     var hits float64
-    for name, _ := range e.algorithms {
+    names := make([]string, 0, len(algorithmMisses))
+    for name, _ := range algorithmMisses {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
         misses = algorithmMisses[name]
         errors = make([]float64, 0)
         for i, _ := range algorithmMisses[name] {
@@ -241,10 +811,10 @@ func (e *engine) plot(algorithmErrors map[string][][]float64, algorithmMisses ma
             misses[i] = misses[i] / (misses[i] + hits) * 100
         }
 
-        errorPlot.SetStyle(fmt.Sprintf("linespoints lt 1 lw 2 pi 2 pt %d linecolor rgb 'black'", graphStyle[graph]))
+        errorPlot.SetStyle(fmt.Sprintf("linespoints lt 1 lw 2 pi 2 pt %d linecolor rgb 'black'", graphStyle[graph % len(graphStyle)]))
         errorPlot.PlotXY(perCycle, errors, name)
 
-        missPlot.SetStyle(fmt.Sprintf("linespoints lt 1 lw 2 pi 2 pt %d linecolor rgb 'black'", graphStyle[graph]))
+        missPlot.SetStyle(fmt.Sprintf("linespoints lt 1 lw 2 pi 2 pt %d linecolor rgb 'black'", graphStyle[graph % len(graphStyle)]))
         missPlot.PlotXY(perCycle, misses, name)
         graph += 1
         fmt.Println(fmt.Sprintf("%v Errors: %v", name, errors))
@@ -284,28 +854,34 @@ func (e *engine) plot(algorithmErrors map[string][][]float64, algorithmMisses ma
     missPlot.CheckedCmd("replot")
 }
 
-func (e *engine) drawLastFrame() {
-    mapWidth := e.config.MapWidth
-    mapHeight := e.config.MapHeight
+// Draws an arrow from a grid of locations in the region to the location estimated by each algorithm.
+// The plot shows the area between the given corners.
+func (e *engine) drawLastFrame(region *Region, plotMin, plotMax *Location) {
     directory := e.config.OutputDir
-    distance := (mapWidth - 170) / 6
+    min, max := region.bounds()
+    xDistance := (max.X - min.X) / 6
+    yDistance := (max.Y - min.Y) / 6
 
     sources := make(map[string][]*Location)
     results := make(map[string][]*Location)
 
     var location *Location
-    var result *Location
+    var result *Estimate
     var signals Signals
     var success bool
-    for x := 85.0 ; x <= mapWidth - 84.0; x += distance {
-        for y := 85.0; y <= mapHeight - 84.0; y += distance {
+    for x := min.X ; x <= max.X + 1.0; x += xDistance {
+        for y := min.Y; y <= max.Y + 1.0; y += yDistance {
             location = NewLocation(x,y)
-            signals = e.m.Read(location)
+            if !region.contains(location) {
+                continue
+            }
+            device := e.config.testDevices()[0]
+            signals = e.read(location, device)
             for name, algorithm := range e.algorithms {
-                result, success = algorithm.read(signals, location)
+                result, success = readDevice(algorithm, signals, location, device)
                 if success {
                     sources[name] = append(sources[name], location)
-                    results[name] = append(results[name], result)
+                    results[name] = append(results[name], result.Location)
                 }
             }
         }
@@ -325,8 +901,8 @@ func (e *engine) drawLastFrame() {
         defer p.Close()
 
         p.CheckedCmd("unset key")
-        p.CheckedCmd(fmt.Sprintf("set xrange [0:%.0f]", mapWidth))
-        p.CheckedCmd(fmt.Sprintf("set yrange [0:%.0f]", mapHeight))
+        p.CheckedCmd(fmt.Sprintf("set xrange [%.0f:%.0f]", plotMin.X, plotMax.X))
+        p.CheckedCmd(fmt.Sprintf("set yrange [%.0f:%.0f]", plotMin.Y, plotMax.Y))
 
         p.SetStyle("linespoints lt 1 lw 1 ps 0.6 pt 1 rgb 'black'")
         for i, _ := range sources[name] {
@@ -337,38 +913,126 @@ func (e *engine) drawLastFrame() {
         p.SetXLabel("X-coordinate")
         p.SetYLabel("Y-coordinate")
         p.CheckedCmd("set terminal pdf")
-        p.CheckedCmd(fmt.Sprintf("set output '%v/%v-full.pdf'", directory, name))
+        p.CheckedCmd(fmt.Sprintf("set output '%v/%v-%v.pdf'", directory, name, strings.ToLower(region.Name)))
         p.CheckedCmd("replot")
     }
 }
 
-func (e *engine) drawLastFrameCenter() {
+// Plots a value per cycle for every algorithm that has one. The label is used for the y axis and the output, the filename is the name of the pdf.
+func (e *engine) plotPerCycle(values map[string][]float64, label string, filename string) {
+    testCycles := e.config.TestCycles
+    directory := e.config.OutputDir
+    perCycle := make([]float64, testCycles + 1)
+    for i := 0; i <= testCycles; i++ {
+        perCycle[i] = float64(i)
+    }
+
+    // Initialize the plotter
+    fname := ""
+    persist := false
+    debug := false
+
+    p,err := gnuplot.NewPlotter(fname, persist, debug)
+    if err != nil {
+        err_string := fmt.Sprintf("** err: %v\n", err)
+        panic(err_string)
+    }
+    defer p.Close()
+
+    p.CheckedCmd(fmt.Sprintf("set xrange [0:%d]", testCycles))
+    p.CheckedCmd("set datafile missing 'NaN'")
+    p.CheckedCmd("set key left top")
+    p.CheckedCmd("set yrange [0:*]")
+
+    graphStyle := []int{4,6,8,12,5,7,9,13}
+    graph := 0
+    for _, name := range e.algorithmNames() {
+        algorithmValues, exists := values[name]
+        if !exists {
+            continue
+        }
+        p.SetStyle(fmt.Sprintf("linespoints lt 1 lw 2 pi 2 pt %d linecolor rgb 'black'", graphStyle[graph % len(graphStyle)]))
+        p.PlotXY(perCycle, algorithmValues, name)
+        graph += 1
+        fmt.Println(fmt.Sprintf("%v %v: %v", name, label, algorithmValues))
+    }
+
+    if os.MkdirAll(directory, 0777) != nil {
+        panic("Unable to create directory for graphs")
+    }
+
+    p.SetXLabel("Cycles")
+    p.SetYLabel(label)
+    p.CheckedCmd("set terminal pdf")
+    p.CheckedCmd(fmt.Sprintf("set output '%v/%v.pdf'", directory, filename))
+    p.CheckedCmd("replot")
+}
+
+// Returns whether any algorithm computes a posterior
+func (e *engine) posteriorEstimators() bool {
+    for _, algorithm := range e.algorithms {
+        if _, ok := algorithm.(posteriorEstimator); ok {
+            return true
+        }
+    }
+    return false
+}
+
+// Draws the posterior of every algorithm that computes one, for a reading in the middle of the region.
+// Darker cells are more probable, and the real location is marked in red.
+func (e *engine) drawPosteriors(region *Region) {
+    min, max := region.bounds()
+    location := NewLocation((min.X + max.X) / 2, (min.Y + max.Y) / 2)
+    signals := e.read(location, e.config.testDevices()[0])
+
+    for _, name := range e.algorithmNames() {
+        posterior, ok := Posterior(e.algorithms[name], e.signals(signals))
+        if !ok {
+            continue
+        }
+        width := int(e.config.MapWidth) + 1
+        height := int(e.config.MapHeight) + 1
+        posteriorImage := image.NewRGBA(image.Rect(0,0,width,height))
+        draw.Draw(posteriorImage, posteriorImage.Bounds(), &image.Uniform{color.RGBA{255,255,255,255}}, image.ZP, draw.Src)
+
+        var highest float64
+        for _, cell := range posterior {
+            highest = math.Max(highest, cell.Probability)
+        }
+        for _, cell := range posterior {
+            shade := uint8(255 - cell.Probability / highest * 255)
+            x, y := int(cell.Location.X - cell.Size / 2), int(cell.Location.Y - cell.Size / 2)
+            draw.Draw(posteriorImage, image.Rect(x,y,x+int(cell.Size),y+int(cell.Size)), &image.Uniform{color.RGBA{shade,shade,shade,255}}, image.ZP, draw.Src)
+        }
+        x, y := int(location.X), int(location.Y)
+        draw.Draw(posteriorImage, image.Rect(x-2,y-2,x+3,y+3), &image.Uniform{color.RGBA{255,0,0,255}}, image.ZP, draw.Src)
+
+        if os.MkdirAll(e.config.OutputDir, 0777) != nil {
+            panic("Unable to create directory for graphs")
+        }
+        file, err := os.Create(filepath.Join(e.config.OutputDir, name + "-posterior.png"))
+        if err != nil {
+            err_string := fmt.Sprintf("** err: %v\n", err)
+            panic(err_string)
+        }
+        png.Encode(file, posteriorImage)
+        file.Close()
+    }
+}
+
+// Draws an arrow from the real location of every access point on the map to the location learned by each algorithm
+func (e *engine) drawAccessPointEstimates() {
     mapWidth := e.config.MapWidth
     mapHeight := e.config.MapHeight
     directory := e.config.OutputDir
-    distance := 330.0 / 6
-    sources := make(map[string][]*Location)
-    results := make(map[string][]*Location)
+    locations := e.m.accessPointLocations()
 
-    var location *Location
-    var result *Location
-    var signals Signals
-    var success bool
-    for x := mapWidth / 2 - 165.0 ; x <= mapWidth / 2 + 165.0; x += distance {
-        for y := mapHeight / 2 - 165.0; y <= mapHeight / 2 + 165.0; y += distance {
-            location = NewLocation(x,y)
-            signals = e.m.Read(location)
-            for name, algorithm := range e.algorithms {
-                result, success = algorithm.read(signals, location)
-                if success {
-                    sources[name] = append(sources[name], location)
-                    results[name] = append(results[name], result)
-                }
-            }
+    for name, algorithm := range e.algorithms {
+        estimates, ok := AccessPointEstimates(algorithm)
+        if !ok {
+            continue
         }
-    }
 
-    for name, _ := range e.algorithms {
         // Initialize the plotters
         fname := ""
         persist := false
@@ -382,19 +1046,21 @@ func (e *engine) drawLastFrameCenter() {
         defer p.Close()
 
         p.CheckedCmd("unset key")
-        p.CheckedCmd(fmt.Sprintf("set xrange [%.0f:%.0f]", mapWidth / 2 - 250.0, mapWidth / 2 + 250.0))
-        p.CheckedCmd(fmt.Sprintf("set yrange [%.0f:%.0f]", mapHeight / 2 - 250.0, mapHeight / 2 + 250.0))
+        p.CheckedCmd(fmt.Sprintf("set xrange [0:%.0f]", mapWidth))
+        p.CheckedCmd(fmt.Sprintf("set yrange [0:%.0f]", mapHeight))
 
         p.SetStyle("linespoints lt 1 lw 1 ps 0.6 pt 1 rgb 'black'")
-        for i, _ := range sources[name] {
-            p.CheckedCmd(fmt.Sprintf("set arrow from %.0f,%.0f to %.0f,%.0f", sources[name][i].X, sources[name][i].Y, results[name][i].X, results[name][i].Y))
+        for _, estimate := range estimates {
+            if location, exists := locations[estimate.ID]; exists {
+                p.CheckedCmd(fmt.Sprintf("set arrow from %.0f,%.0f to %.0f,%.0f", location.X, location.Y, estimate.Location.X, estimate.Location.Y))
+            }
         }
         p.PlotXY([]float64{0,0}, []float64{0,0}, "")
 
         p.SetXLabel("X-coordinate")
         p.SetYLabel("Y-coordinate")
         p.CheckedCmd("set terminal pdf")
-        p.CheckedCmd(fmt.Sprintf("set output '%v/%v-center.pdf'", directory, name))
+        p.CheckedCmd(fmt.Sprintf("set output '%v/%v-accesspoints.pdf'", directory, name))
         p.CheckedCmd("replot")
     }
-}
\ No newline at end of file
+}
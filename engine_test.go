@@ -0,0 +1,73 @@
+package wifi
+
+import (
+    "testing"
+)
+
+// Runs a few cycles of a small simulation with the given number of workers, and returns the estimates of every algorithm
+func simulate(t *testing.T, workers int) map[string][]result {
+    c := NewConfiguration()
+    c.OutputDir = t.TempDir()
+    c.MapWidth = 300
+    c.MapHeight = 300
+    c.AccessPointDensity = 1500
+    c.SeedDistance = 10
+    c.TestDistance = 20
+    c.TestCycles = 3
+    c.ReplacementRate = 0.1
+    c.ReplacementStrategy = FiFoReplacement
+    c.RandomSeed = 42
+    c.Workers = workers
+
+    e := NewEngine(c)
+    e.AddAlgorithm("Centroid", NewCentroid())
+    e.AddAlgorithm("Learning Centroid", NewLearningCentroid())
+    e.AddAlgorithm("Learning Fingerprinting", NewLearningFingerprinting())
+    e.AddAlgorithm("WKNN Fingerprinting", NewWKNNFingerprinting(4, EuclideanDistance, -100))
+    e.AddAlgorithm("Uncertainty Ensemble", NewUncertaintyEnsemble(NewCentroid(), NewWKNNFingerprinting(4, EuclideanDistance, -100)))
+    e.AddAlgorithm("Switching Ensemble", NewSwitchingEnsemble(NewCentroid(), NewLearningCentroid()))
+    e.seed()
+
+    var locations []*Location
+    min, max := c.testRegion().bounds()
+    for x := min.X; x <= max.X; x += c.TestDistance {
+        for y := min.Y; y <= max.Y; y += c.TestDistance {
+            locations = append(locations, NewLocation(x, y))
+        }
+    }
+
+    estimates := make(map[string][]result)
+    for cycle := 0; cycle <= c.TestCycles; cycle++ {
+        if cycle != 0 {
+            e.replaceAccessPoints()
+        }
+        results, _ := e.readAll(locations, 0)
+        for name, _ := range e.algorithms {
+            estimates[name] = append(estimates[name], results[name]...)
+        }
+    }
+    return estimates
+}
+
+func TestWorkersGiveIdenticalResults(t *testing.T) {
+    serial := simulate(t, 1)
+    parallel := simulate(t, 4)
+    for name, serialResults := range serial {
+        parallelResults := parallel[name]
+        if len(parallelResults) != len(serialResults) {
+            t.Fatalf("%v: %d results with 1 worker, %d with 4 workers", name, len(serialResults), len(parallelResults))
+        }
+        for i, expected := range serialResults {
+            actual := parallelResults[i]
+            if actual.success != expected.success {
+                t.Fatalf("%v: read %d succeeded with 1 worker: %v, with 4 workers: %v", name, i, expected.success, actual.success)
+            }
+            if !expected.success {
+                continue
+            }
+            if *actual.estimate.Location != *expected.estimate.Location || actual.estimate.Radius != expected.estimate.Radius {
+                t.Fatalf("%v: read %d estimated %v with 1 worker, %v with 4 workers", name, i, expected.estimate.Location, actual.estimate.Location)
+            }
+        }
+    }
+}
@@ -0,0 +1,252 @@
+package wifi
+
+import (
+    "math"
+)
+
+// Ways an ensemble fuses the estimates of its algorithms
+const (
+    fixedWeightFusion = 1
+    uncertaintyFusion = 2
+    switchingFusion = 3
+)
+
+// Every holdoutInterval-th seed reading of a switching ensemble is held out to evaluate its algorithms, instead of fed to them
+const holdoutInterval = 10
+
+// Readings are grouped by their number of access points in steps of switchingBucketSize, and at most switchingBuckets groups
+const switchingBucketSize = 3
+const switchingBuckets = 6
+
+//////////////
+// Ensemble //
+//////////////
+
+// An ensemble reads with several algorithms and fuses their estimates. Algorithms that return no estimate are left out.
+type ensemble struct {
+    algorithms []algorithm
+    fusion int
+    // The weights of the algorithms for fixed weight fusion
+    weights []float64
+    // The seed readings that are held out, until they are evaluated when all seed readings are fed
+    feeds int
+    holdouts []holdout
+    seeding bool
+    // The sum of the errors and the number of held out readings per algorithm per bucket
+    errors [][switchingBuckets]float64
+    counts [][switchingBuckets]float64
+}
+
+type holdout struct {
+    signals Signals
+    location *Location
+    device *Device
+}
+
+// Returns an ensemble that averages the estimates of the algorithms with the given weights
+func NewWeightedEnsemble(weights []float64, algorithms ...algorithm) algorithm {
+    if len(weights) != len(algorithms) {
+        err_string := "** err: An ensemble needs a weight for every algorithm"
+        panic(err_string)
+    }
+    for _, weight := range weights {
+        if weight < 0 {
+            err_string := "** err: Ensemble weights cannot be negative"
+            panic(err_string)
+        }
+    }
+    return newEnsemble(fixedWeightFusion, weights, algorithms)
+}
+
+// Returns an ensemble that averages the estimates of the algorithms weighted by the inverse of their expected squared error
+func NewUncertaintyEnsemble(algorithms ...algorithm) algorithm {
+    return newEnsemble(uncertaintyFusion, nil, algorithms)
+}
+
+// Returns an ensemble that uses the estimate of the algorithm with the lowest error on held out seed readings
+// with a similar number of access points. The held out readings are not fed to the algorithms, and are evaluated once
+// all seed readings are fed.
+// Algorithms that are modified by reads cannot be evaluated without side effects. They are fed the held out readings
+// instead, and are only used when no evaluated algorithm has an estimate.
+func NewSwitchingEnsemble(algorithms ...algorithm) algorithm {
+    return newEnsemble(switchingFusion, nil, algorithms)
+}
+
+func newEnsemble(fusion int, weights []float64, algorithms []algorithm) *ensemble {
+    if len(algorithms) == 0 {
+        err_string := "** err: An ensemble needs at least one algorithm"
+        panic(err_string)
+    }
+    return &ensemble{algorithms, fusion, weights, 0, nil, true, make([][switchingBuckets]float64, len(algorithms)), make([][switchingBuckets]float64, len(algorithms))}
+}
+
+func (e *ensemble) sequential() bool {
+    for _, a := range e.algorithms {
+        if a.sequential() {
+            return true
+        }
+    }
+    return false
+}
+
+func (e *ensemble) feed(signals Signals, location *Location) {
+    e.feedDevice(signals, location, nil)
+}
+
+func (e *ensemble) read(signals Signals, location *Location) (*Estimate, bool) {
+    return e.readDevice(signals, location, nil)
+}
+
+func (e *ensemble) feedDevice(signals Signals, location *Location, device *Device) {
+    if e.fusion == switchingFusion && e.seeding {
+        e.feeds += 1
+        if e.feeds % holdoutInterval == 0 {
+            held := make(Signals, len(signals))
+            copy(held, signals)
+            e.holdouts = append(e.holdouts, holdout{held, location, device})
+            for _, a := range e.algorithms {
+                if a.sequential() {
+                    feedDevice(a, signals, location, device)
+                }
+            }
+            return
+        }
+    }
+    for _, a := range e.algorithms {
+        feedDevice(a, signals, location, device)
+    }
+}
+
+// Evaluates the held out readings, after which no more readings are held out
+func (e *ensemble) seeded() {
+    for _, a := range e.algorithms {
+        seeded(a)
+    }
+    e.evaluateHoldouts()
+    e.seeding = false
+}
+
+func (e *ensemble) readDevice(signals Signals, location *Location, device *Device) (*Estimate, bool) {
+    estimates := make([]*Estimate, len(e.algorithms))
+    for i, a := range e.algorithms {
+        estimate, success := readDevice(a, signals, location, device)
+        if success {
+            estimates[i] = estimate
+        }
+    }
+
+    weights := make([]float64, len(e.algorithms))
+    for i, estimate := range estimates {
+        if estimate == nil {
+            continue
+        }
+        switch e.fusion {
+        case fixedWeightFusion:
+            weights[i] = e.weights[i]
+        case uncertaintyFusion:
+            weights[i] = 1 / math.Max(estimate.Radius * estimate.Radius, 1)
+        }
+    }
+    if e.fusion == switchingFusion {
+        if best := e.best(estimates, bucket(signals)); best >= 0 {
+            weights[best] = 1
+        }
+    }
+    return fuse(estimates, weights)
+}
+
+// Reads the held out readings with every algorithm that is not modified by reads, and records their errors
+func (e *ensemble) evaluateHoldouts() {
+    for _, h := range e.holdouts {
+        b := bucket(h.signals)
+        for i, a := range e.algorithms {
+            if a.sequential() {
+                continue
+            }
+            estimate, success := readDevice(a, h.signals, h.location, h.device)
+            if success {
+                e.errors[i][b] += distance(estimate.Location, h.location)
+                e.counts[i][b] += 1
+            }
+        }
+    }
+    e.holdouts = nil
+}
+
+// Returns the index of the algorithm with an estimate that has the lowest mean error in the bucket.
+// Algorithms without held out results in the bucket are compared by their mean error over all buckets.
+// Returns -1 if no algorithm has an estimate.
+func (e *ensemble) best(estimates []*Estimate, b int) int {
+    best := -1
+    var bestError float64
+    for i, estimate := range estimates {
+        if estimate == nil {
+            continue
+        }
+        meanError := e.meanError(i, b)
+        if best < 0 || meanError < bestError {
+            best = i
+            bestError = meanError
+        }
+    }
+    return best
+}
+
+func (e *ensemble) meanError(i, b int) float64 {
+    if e.counts[i][b] > 0 {
+        return e.errors[i][b] / e.counts[i][b]
+    }
+    var errors, counts float64
+    for other, _ := range e.counts[i] {
+        errors += e.errors[i][other]
+        counts += e.counts[i][other]
+    }
+    if counts == 0 {
+        return math.Inf(1)
+    }
+    return errors / counts
+}
+
+// Returns the bucket of a reading, by its number of access points
+func bucket(signals Signals) int {
+    b := len(signals) / switchingBucketSize
+    if b >= switchingBuckets {
+        return switchingBuckets - 1
+    }
+    return b
+}
+
+// Returns the weighted average of the estimates. Its radius covers the radii of the estimates and their spread around the average.
+// Returns false if no estimate has a positive weight.
+func fuse(estimates []*Estimate, weights []float64) (*Estimate, bool) {
+    var total float64
+    var locations []*Location
+    var locationWeights []float64
+    for i, estimate := range estimates {
+        if estimate != nil && weights[i] > 0 {
+            total += weights[i]
+            locations = append(locations, estimate.Location)
+            locationWeights = append(locationWeights, weights[i])
+        }
+    }
+    if total == 0 {
+        return nil, false
+    }
+    location := weightedAverage(locations, locationWeights)
+    fused := &Estimate{location, 0, 0, 0, nil, nil}
+    var variance float64
+    for i, estimate := range estimates {
+        if estimate == nil || weights[i] <= 0 {
+            continue
+        }
+        weight := weights[i] / total
+        offset := distance(estimate.Location, location)
+        variance += weight * (estimate.Radius * estimate.Radius + offset * offset)
+        fused.MatchDistance += weight * estimate.MatchDistance
+        if estimate.AccessPoints > fused.AccessPoints {
+            fused.AccessPoints = estimate.AccessPoints
+        }
+    }
+    fused.Radius = math.Sqrt(variance)
+    return fused, true
+}
@@ -0,0 +1,47 @@
+package wifi
+
+import (
+    "math"
+)
+
+// An Estimate is a location estimated by an algorithm, along with how far off it is expected to be
+type Estimate struct {
+    Location *Location
+    // The expected root mean square error of the location in m. For well calibrated estimates,
+    // the real location is within the radius about 63% of the time.
+    Radius float64
+    // The number of access points the estimate is based on
+    AccessPoints int
+    // The signal distance between the reading and the fingerprints the estimate is based on,
+    // or 0 for algorithms that do not match fingerprints
+    MatchDistance float64
+    // The IDs of the access points of the reading that the estimate is based on, and that were rejected as inconsistent
+    // with the others. Both are nil for algorithms that do not report them. Access points the algorithm does not know are in neither.
+    Inliers []int
+    Outliers []int
+}
+
+// The largest expected error in m of the estimates that enhanced learning algorithms feed back
+const defaultConfidenceRadius = 20.0
+
+// The weight of samples at estimated locations, relative to samples at surveyed locations
+const learnedSampleWeight = 0.5
+
+// Returns the weighted root mean square distance between the locations and the center.
+// When weights is nil, all locations weigh the same.
+func spread(locations []*Location, weights []float64, center *Location) float64 {
+    var sum, total float64
+    for i, location := range locations {
+        weight := 1.0
+        if weights != nil {
+            weight = weights[i]
+        }
+        dx, dy := location.X - center.X, location.Y - center.Y
+        sum += weight * (dx * dx + dy * dy)
+        total += weight
+    }
+    if total == 0 {
+        return 0
+    }
+    return math.Sqrt(sum / total)
+}
@@ -9,6 +9,16 @@ import (
 type fingerprint struct {
     signals Signals
     location *Location
+    // The number and total weight of the readings merged into this fingerprint
+    count int
+    weight float64
+    // The order in which the fingerprint was added to the database
+    added int
+}
+
+// Returns the mean weight of the readings of the fingerprint
+func (f fingerprint) trust() float64 {
+    return f.weight / float64(f.count)
 }
 
 type fingerprints []fingerprint
@@ -21,6 +31,14 @@ func (fingerprints fingerprints) locations() []*Location {
     return locations
 }
 
+func (fingerprints fingerprints) trust() []float64 {
+    trust := make([]float64, len(fingerprints))
+    for i, fingerprint := range fingerprints {
+        trust[i] = fingerprint.trust()
+    }
+    return trust
+}
+
 func (fingerprints fingerprints) signals() []Signals {
     signals := make([]Signals, len(fingerprints))
     for i, fingerprint := range fingerprints {
@@ -29,130 +47,478 @@ func (fingerprints fingerprints) signals() []Signals {
     return signals
 }
 
-type food struct {
-    location *Location
-    signals Signals
+// Merges a reading with the same key into the running mean of the fingerprint
+func (f *fingerprint) merge(signals Signals, location *Location, readingWeight float64) {
+    f.count += 1
+    f.weight += readingWeight
+    weight := readingWeight / f.weight
+    for i, signal := range signals {
+        f.signals[i].signalStrength += (signal.signalStrength - f.signals[i].signalStrength) * weight
+    }
+    f.location = NewLocation(f.location.X + (location.X - f.location.X) * weight, f.location.Y + (location.Y - f.location.Y) * weight)
+}
+
+// A FingerprintingOption configures a fingerprinting algorithm
+type FingerprintingOption func(f *fingerprinting)
+
+// Merges a reading into a fingerprint with the same key when their signal strengths are closer than the given distance
+func MergeFingerprints(distance float64) FingerprintingOption {
+    return func(f *fingerprinting) {
+        f.mergeDistance = distance
+    }
+}
+
+// Keeps at most count fingerprints in every square cell of the given size in m, removing the oldest first
+func FingerprintCapacity(cellSize float64, count int) FingerprintingOption {
+    if cellSize <= 0 || count < 1 {
+        err_string := "** err: Capacity needs a positive cell size and at least 1 fingerprint"
+        panic(err_string)
+    }
+    return func(f *fingerprinting) {
+        f.cellSize = cellSize
+        f.capacity = count
+    }
+}
+
+// Removes access points that have not been seen for the given number of reads from all fingerprints
+func FingerprintAging(reads int) FingerprintingOption {
+    return func(f *fingerprinting) {
+        f.aging = newAging(reads)
+    }
 }
 
+// Removes an access point when estimates are more than threshold meters from where it was seen, count times in a row
+func FingerprintRelocationDetection(threshold float64, count int) FingerprintingOption {
+    return func(f *fingerprinting) {
+        f.relocation = newRelocationDetection(threshold, count)
+    }
+}
+
+// Learns only from reads whose radius is within the given value in m
+func FingerprintConfidence(radius float64) FingerprintingOption {
+    if radius < 0 {
+        err_string := "** err: Confidence radius cannot be negative"
+        panic(err_string)
+    }
+    return func(f *fingerprinting) {
+        f.confidence = radius
+    }
+}
+
+// Gives learned fingerprints the given weight when matching and merging, where seed fingerprints weigh 1
+func FingerprintFeedbackWeight(weight float64) FingerprintingOption {
+    if weight <= 0 || weight > 1 {
+        err_string := "** err: Feedback weight must be between 0 and 1"
+        panic(err_string)
+    }
+    return func(f *fingerprinting) {
+        f.feedbackWeight = weight
+    }
+}
+
+// The sum of the locations an access point was observed at
+type observedLocation struct {
+    sumX, sumY float64
+    count int
+}
+
+func (o *observedLocation) mean() *Location {
+    return NewLocation(o.sumX / float64(o.count), o.sumY / float64(o.count))
+}
+
+// The merge distance, cell size in m and fingerprints per cell used by smart fingerprinting
+const defaultMergeDistance = 4.0
+const defaultCapacityCell = 10.0
+const defaultCapacity = 4
+
 type fingerprinting struct {
     fingerprintMap map[Key]fingerprints
-    food []food
+    // The keys in fingerprintMap that contain each access point ID, in the order they were added
+    index map[int][]Key
     bestMatches int
     enhanced bool
     learning bool
     smart bool
+    mergeDistance float64
+    cellSize float64
+    capacity int
+    // The fingerprints in every cell in the order they were added, and the key every fingerprint is stored under
+    added int
+    cells map[cellKey][]int
+    keys map[int]Key
+    aging aging
+    relocation relocationDetection
+    observations map[int]*observedLocation
+    // A confidence of 0 learns from every read
+    confidence float64
+    feedbackWeight float64
+
+    // Weighted k-nearest-neighbour matching, see wknn.go
+    wknn bool
+    metric int
+    floor float64
+    statistics map[int]*signalStatistics
+}
+
+// Enhanced fingerprinting ignores empty readings, and only learns from confident reads with a lower weight
+func newFingerprinting(enhanced, learning, smart bool) *fingerprinting {
+    f := &fingerprinting{
+        fingerprintMap: make(map[Key]fingerprints),
+        index: make(map[int][]Key),
+        bestMatches: 4,
+        enhanced: enhanced,
+        learning: learning,
+        smart: smart,
+        statistics: make(map[int]*signalStatistics),
+        aging: newAging(0),
+        relocation: newRelocationDetection(0, 0),
+        observations: make(map[int]*observedLocation),
+        feedbackWeight: 1,
+        cells: make(map[cellKey][]int),
+        keys: make(map[int]Key),
+    }
+    if smart {
+        f.mergeDistance = defaultMergeDistance
+        f.cellSize = defaultCapacityCell
+        f.capacity = defaultCapacity
+    }
+    if enhanced {
+        f.confidence = defaultConfidenceRadius
+        f.feedbackWeight = learnedSampleWeight
+    }
+    return f
+}
+
+func (f *fingerprinting) apply(options []FingerprintingOption) *fingerprinting {
+    for _, option := range options {
+        option(f)
+    }
+    return f
 }
 
-func NewFingerprinting() algorithm {
-    return algorithm(&fingerprinting{make(map[Key]fingerprints), nil, 4, false, false, false})
+func NewFingerprinting(options ...FingerprintingOption) algorithm {
+    return algorithm(newFingerprinting(false, false, false).apply(options))
 }
 
-func NewEnhancedFingerprinting() algorithm {
-    return algorithm(&fingerprinting{make(map[Key]fingerprints), nil, 4, true, false, false})
+func NewEnhancedFingerprinting(options ...FingerprintingOption) algorithm {
+    return algorithm(newFingerprinting(true, false, false).apply(options))
 }
 
-func NewLearningFingerprinting() algorithm {
-    return algorithm(&fingerprinting{make(map[Key]fingerprints), nil, 4, false, true, false})
+func NewLearningFingerprinting(options ...FingerprintingOption) algorithm {
+    return algorithm(newFingerprinting(false, true, false).apply(options))
 }
 
-func NewEnhancedLearningFingerprinting() algorithm {
-    return algorithm(&fingerprinting{make(map[Key]fingerprints), nil, 4, true, true, false})
+func NewEnhancedLearningFingerprinting(options ...FingerprintingOption) algorithm {
+    return algorithm(newFingerprinting(true, true, false).apply(options))
 }
 
-func NewSmartLearningFingerprinting() algorithm {
-    return algorithm(&fingerprinting{make(map[Key]fingerprints), nil, 4, false, true, true})
+// Smart learning fingerprinting merges similar readings, and bounds the fingerprints per cell
+func NewSmartLearningFingerprinting(options ...FingerprintingOption) algorithm {
+    return algorithm(newFingerprinting(false, true, true).apply(options))
 }
 
 func (f *fingerprinting) feed(signals Signals, location *Location) {
+    f.feedWeighted(signals, location, 1)
+}
+
+func (f *fingerprinting) feedWeighted(signals Signals, location *Location, weight float64) {
     if f.enhanced && len(signals) == 0 {
         return
     }
 
+    // Shadow fading lets readings hold more access points than fit in a key
+    signals = signals.strongest(keyLength)
     sort.Sort(ByID(signals))
     key := signals.Key()
-    // if f.smart {
-    //     var signalsList []Signals
-    //     for mapKey, mapFingerprints := range f.fingerprintMap {
-    //         if setDifference(key, mapKey) == 0 {
-    //             signalsList = append(signalsList, mapFingerprints.signals() ...)
-    //         }
-    //     }
-    //     for _, mapSignals := range signalsList {
-    //         if euclidianDistance(signals, mapSignals) < 4 {
-    //             return
-    //         }
-    //     }
-    // }
-    fingerprint := fingerprint{signals, location}
+    f.aging.observe(signals)
+
+    if f.relocation.enabled() {
+        for _, signal := range signals {
+            observation, exists := f.observations[signal.id]
+            if !exists {
+                observation = &observedLocation{}
+                f.observations[signal.id] = observation
+            }
+            observation.sumX += location.X
+            observation.sumY += location.Y
+            observation.count += 1
+        }
+    }
+
+    if f.metric == StandardizedDistance {
+        for _, signal := range signals {
+            f.signalStatistics(signal.id).add(signal.signalStrength)
+        }
+    }
+
+    if f.mergeDistance > 0 {
+        closest := -1
+        closestDistance := f.mergeDistance
+        for i, fingerprint := range f.fingerprintMap[key] {
+            if dist := euclidianDistance(signals, fingerprint.signals); dist < closestDistance {
+                closest = i
+                closestDistance = dist
+            }
+        }
+        if closest >= 0 {
+            f.fingerprintMap[key][closest].merge(signals, location, weight)
+            return
+        }
+        // Merging modifies the stored signals, so they must not be shared with the caller
+        signalsCopy := make(Signals, len(signals))
+        copy(signalsCopy, signals)
+        signals = signalsCopy
+    }
+
+    f.added += 1
+    f.store(fingerprint{signals, location, 1, weight, f.added})
+    if f.capacity > 0 {
+        f.limit(cellKey{int(math.Floor(location.X / f.cellSize)), int(math.Floor(location.Y / f.cellSize))}, f.added)
+    }
+}
+
+// Adds the fingerprint to the cell, and removes the oldest fingerprints of the cell beyond the capacity
+func (f *fingerprinting) limit(cell cellKey, added int) {
+    var kept []int
+    for _, other := range f.cells[cell] {
+        // Fingerprints without access points are already evicted
+        if _, exists := f.keys[other]; exists {
+            kept = append(kept, other)
+        }
+    }
+    kept = append(kept, added)
+    for len(kept) > f.capacity {
+        f.remove(kept[0])
+        kept = kept[1:]
+    }
+    f.cells[cell] = kept
+}
+
+// Removes a fingerprint from the database
+func (f *fingerprinting) remove(added int) {
+    key := f.keys[added]
+    delete(f.keys, added)
+    stored := f.fingerprintMap[key]
+    for i, fingerprint := range stored {
+        if fingerprint.added == added {
+            stored = append(stored[:i], stored[i+1:]...)
+            break
+        }
+    }
+    if len(stored) > 0 {
+        f.fingerprintMap[key] = stored
+        return
+    }
+    delete(f.fingerprintMap, key)
+    for i := 0; i < keyLength && key[i] != 0; i++ {
+        kept := f.index[key[i]][:0]
+        for _, indexKey := range f.index[key[i]] {
+            if indexKey != key {
+                kept = append(kept, indexKey)
+            }
+        }
+        f.index[key[i]] = kept
+    }
+}
+
+// Adds a fingerprint to the database. The signals of the fingerprint MUST be sorted by ID.
+func (f *fingerprinting) store(fingerprint fingerprint) {
+    key := fingerprint.signals.Key()
+    if _, exists := f.fingerprintMap[key]; !exists {
+        for _, signal := range fingerprint.signals {
+            f.index[signal.id] = append(f.index[signal.id], key)
+        }
+    }
     f.fingerprintMap[key] = append(f.fingerprintMap[key], fingerprint)
+    if f.capacity > 0 {
+        f.keys[fingerprint.added] = key
+    }
 }
 
+// Removes the stale access points from all fingerprints, and restores the fingerprints under their new key
+func (f *fingerprinting) evict(stale []int) {
+    if len(stale) == 0 {
+        return
+    }
+
+    // Collect the affected keys in index order, and remove them from the database
+    isStale := make(map[int]bool)
+    affected := make(map[Key]bool)
+    var keys []Key
+    for _, id := range stale {
+        isStale[id] = true
+        for _, key := range f.index[id] {
+            if !affected[key] {
+                affected[key] = true
+                keys = append(keys, key)
+            }
+        }
+        delete(f.index, id)
+        delete(f.statistics, id)
+        delete(f.observations, id)
+    }
+
+    var remaining fingerprints
+    var signals Signals
+    for _, key := range keys {
+        for _, fingerprint := range f.fingerprintMap[key] {
+            signals = make(Signals, 0, len(fingerprint.signals))
+            for _, signal := range fingerprint.signals {
+                if !isStale[signal.id] {
+                    signals = append(signals, signal)
+                }
+            }
+            if len(signals) > 0 {
+                remaining = append(remaining, fingerprint)
+                remaining[len(remaining)-1].signals = signals
+            } else {
+                delete(f.keys, fingerprint.added)
+            }
+        }
+        delete(f.fingerprintMap, key)
+    }
+
+    // Remove the deleted keys from the index of the other access points they contain
+    pruned := make(map[int]bool)
+    for _, key := range keys {
+        for i := 0; i < keyLength && key[i] != 0; i++ {
+            id := key[i]
+            if isStale[id] || pruned[id] {
+                continue
+            }
+            pruned[id] = true
+            kept := f.index[id][:0]
+            for _, indexKey := range f.index[id] {
+                if !affected[indexKey] {
+                    kept = append(kept, indexKey)
+                }
+            }
+            f.index[id] = kept
+        }
+    }
 
-func (f *fingerprinting) read(signals Signals, realLocation *Location) (*Location, bool) {
-    pointerMap := make([]fingerprints, 50)
+    for _, fingerprint := range remaining {
+        f.store(fingerprint)
+    }
+}
+
+// Returns the IDs of the access points in the signals that are relocated, given the estimated location
+func (f *fingerprinting) detectRelocations(signals Signals, location *Location) []int {
+    var relocated []int
+    for _, signal := range signals {
+        observation, exists := f.observations[signal.id]
+        if exists && observation.count >= minObservations && f.relocation.relocated(signal.id, observation.mean(), location) {
+            relocated = append(relocated, signal.id)
+        }
+    }
+    return relocated
+}
+
+// The minimum number of observations of an access point before it can be considered relocated
+const minObservations = 4
+
+// Returns the number of fingerprints in the database
+func (f *fingerprinting) databaseSize() int {
+    size := 0
+    for _, fingerprints := range f.fingerprintMap {
+        size += len(fingerprints)
+    }
+    return size
+}
+
+
+func (f *fingerprinting) sequential() bool {
+    return f.learning || f.aging.enabled() || f.relocation.enabled()
+}
+
+func (f *fingerprinting) read(signals Signals, realLocation *Location) (*Estimate, bool) {
+    signals = signals.strongest(keyLength)
     sort.Sort(ByID(signals))
+    f.aging.observe(signals)
+    f.evict(f.aging.read())
+    var estimate *Estimate
+    if f.wknn {
+        estimate = f.nearestNeighbours(signals)
+    } else {
+        estimate = f.bestMatch(signals)
+    }
+    if estimate == nil {
+        return nil, false
+    }
+
+    if f.relocation.enabled() {
+        f.evict(f.detectRelocations(signals, estimate.Location))
+    }
+
+    if f.learning && (f.confidence == 0 || estimate.Radius <= f.confidence) {
+        f.feedWeighted(signals, estimate.Location, f.feedbackWeight)
+    }
+    return estimate, true
+}
+
+// Returns the trust weighted average location of the fingerprints with the fewest missing access points, ties broken by
+// euclidian distance. Returns nil if no fingerprint shares an access point with the signals.
+func (f *fingerprinting) bestMatch(signals Signals) *Estimate {
+    return f.match(signals, f.candidates(signals))
+}
+
+// Returns the best match among the fingerprints stored under the given keys
+func (f *fingerprinting) match(signals Signals, keys []Key) *Estimate {
+    pointerMap := make([]fingerprints, keyLength + 1)
     ids := signals.Key()
     var dist int
-    for key, fingerprints := range f.fingerprintMap {
+    for _, key := range keys {
         dist = setDifference(ids, key)
-        pointerMap[dist] = append(pointerMap[dist], fingerprints...)
+        pointerMap[dist] = append(pointerMap[dist], f.fingerprintMap[key]...)
     }
 
     var locations []*Location
+    var trust []float64
     var breakers fingerprints
+    matchDistance := -1
     for distance, fingerprints := range pointerMap {
         if distance == len(signals) {
             break
         }
+        if matchDistance < 0 && len(fingerprints) > 0 {
+            matchDistance = distance
+        }
         if len(locations) + len(fingerprints) > f.bestMatches {
             breakers = fingerprints
             break
         }
         locations = append(locations, fingerprints.locations()...)
+        trust = append(trust, fingerprints.trust()...)
     }
 
     if locations == nil && breakers == nil {
-        return nil, false
-    } else {
-        if breakers != nil {
-            s := make([]stuf, len(breakers))
-            for i, fingerprint := range breakers {
-                s[i] = stuf{fingerprint.location, euclidianDistance(signals, fingerprint.signals)}
-            }
-            sort.Sort(ByDistance(s))
-
-            for _, stuf := range s[:f.bestMatches - len(locations)] {
-                locations = append(locations, stuf.location)
-            }
+        return nil
+    }
+    if breakers != nil {
+        s := make([]stuf, len(breakers))
+        for i, fingerprint := range breakers {
+            s[i] = stuf{fingerprint.location, euclidianDistance(signals, fingerprint.signals), fingerprint.trust()}
         }
-        location := average(locations)
-
-        // if f.enhanced {
-        //     location.enhance(realLocation)
-        // }
-
-        if f.learning {
-            if f.smart {
-                f.food = append(f.food, food{location, signals})
-                if len(f.food) > 1000 {
-                    fmt.Println("Batch feeding")
-                    for _, food := range f.food {
-                        f.feed(food.signals, food.location)
-                    }
-                    f.food = nil
-                }
-            } else {
-                f.feed(signals, location)
-            }
-            
+        sort.Sort(ByDistance(s))
+
+        for _, stuf := range s[:f.bestMatches - len(locations)] {
+            locations = append(locations, stuf.location)
+            trust = append(trust, stuf.trust)
         }
-        return location, true
     }
+    location := weightedAverage(locations, trust)
+    radius := spread(locations, trust, location)
+    // A single match has no spread
+    if len(locations) < 2 {
+        radius = maxReceptionDistance / math.Sqrt2
+    }
+    return &Estimate{location, radius, len(signals), float64(matchDistance), nil, nil}
 }
 
 type stuf struct {
     location *Location
     distance float64
+    trust float64
 }
 
 type ByDistance []stuf
@@ -160,13 +526,14 @@ func (s ByDistance) Len() int           { return len(s) }
 func (s ByDistance) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s ByDistance) Less(i, j int) bool { return s[i].distance < s[j].distance }
 
-func average(locations []*Location) *Location {
-    var x, y float64 = 0, 0
-    for _, location := range locations {
-        x += location.X
-        y += location.Y
+func weightedAverage(locations []*Location, weights []float64) *Location {
+    var x, y, total float64 = 0, 0, 0
+    for i, location := range locations {
+        x += location.X * weights[i]
+        y += location.Y * weights[i]
+        total += weights[i]
     }
-    return &Location{x / float64(len(locations)), y / float64(len(locations))}
+    return &Location{x / total, y / total}
 }
 
 // signals MUST be sorted by ID
@@ -190,6 +557,38 @@ func euclidianDistance(signals1, signals2 Signals) float64 {
     return math.Sqrt(sum)
 }
 
+// signals MUST be sorted by ID
+// Returns every key that shares an access point with the signals once, in the order of the first shared ID
+func (f *fingerprinting) candidates(signals Signals) []Key {
+    ids := signals.Key()
+    var keys []Key
+    for _, signal := range signals {
+        for _, key := range f.index[signal.id] {
+            if firstSharedID(ids, key) == signal.id {
+                keys = append(keys, key)
+            }
+        }
+    }
+    return keys
+}
+
+// keys MUST be sorted
+// Returns the lowest ID that appears in both ids1 and ids2, or 0 if there is none
+func firstSharedID(ids1, ids2 Key) int {
+    i1 := 0
+    i2 := 0
+    for i1 < keyLength && i2 < keyLength && ids1[i1] != 0 && ids2[i2] != 0 {
+        if ids1[i1] < ids2[i2] {
+            i1++
+        } else if ids1[i1] > ids2[i2] {
+            i2++
+        } else {
+            return ids1[i1]
+        }
+    }
+    return 0
+}
+
 // keys MUST be sorted
 // Returns the number of elements that appear in ids1 but NOT in ids2
 func setDifference(ids1, ids2 Key) int {
@@ -219,7 +618,7 @@ func setDifference(ids1, ids2 Key) int {
 
 func spearman(vector1 []int, vector2 []int) float32 {
     if len(vector1) != len(vector2) {
-        fmt.Println("Error, unequal vector lengths: %v %v", vector1, vector2)
+        fmt.Printf("Error, unequal vector lengths: %v %v\n", vector1, vector2)
     }
     n := len(vector1)
     tmp := make([]int, n)
@@ -0,0 +1,146 @@
+package wifi
+
+import (
+    "math"
+    "math/rand"
+    "sort"
+    "testing"
+)
+
+// Returns a map of the given size with the given number of access points per km^2, and locations every spacing meters
+func testMap(size, density, spacing float64) (*Map, []*Location) {
+    random := rand.New(rand.NewSource(42))
+    m := NewMap(size, size, rand.New(rand.NewSource(43)), rand.New(rand.NewSource(44)))
+    for i := 0; i < int(size * size * density / 1000000); i++ {
+        m.AddRandomAccessPoint(random)
+    }
+    var locations []*Location
+    for x := spacing / 2; x < size; x += spacing {
+        for y := spacing / 2; y < size; y += spacing {
+            locations = append(locations, NewLocation(x, y))
+        }
+    }
+    return m, locations
+}
+
+func TestCandidatesShareAnAccessPoint(t *testing.T) {
+    m, locations := testMap(300, 1500, 10)
+    f := newFingerprinting(false, false, false)
+    for _, location := range locations {
+        f.feed(m.Read(location), location)
+    }
+
+    for _, location := range locations {
+        signals := m.Read(NewLocation(location.X + 3, location.Y + 3))
+        sort.Sort(ByID(signals))
+        ids := signals.Key()
+        returned := make(map[Key]int)
+        for _, key := range f.candidates(signals) {
+            returned[key] += 1
+        }
+        for key, _ := range f.fingerprintMap {
+            expected := 0
+            if firstSharedID(ids, key) != 0 {
+                expected = 1
+            }
+            if returned[key] != expected {
+                t.Fatalf("key %v returned %d times for %v, expected %d", key, returned[key], ids, expected)
+            }
+        }
+    }
+}
+
+func TestBestMatchEqualsFullScan(t *testing.T) {
+    m, locations := testMap(300, 1500, 10)
+    f := newFingerprinting(false, false, false)
+    for _, location := range locations {
+        f.feed(m.Read(location), location)
+    }
+    var keys []Key
+    for key, _ := range f.fingerprintMap {
+        keys = append(keys, key)
+    }
+
+    for _, location := range locations {
+        signals := m.Read(NewLocation(location.X + 3, location.Y + 3))
+        sort.Sort(ByID(signals))
+        indexed := f.bestMatch(signals)
+        scanned := f.match(signals, keys)
+        if (indexed == nil) != (scanned == nil) {
+            t.Fatalf("%v: indexed match %v, full scan %v", location, indexed, scanned)
+        }
+        if indexed == nil {
+            continue
+        }
+        if distance(indexed.Location, scanned.Location) > 1e-9 || math.Abs(indexed.Radius - scanned.Radius) > 1e-9 ||
+            indexed.MatchDistance != scanned.MatchDistance {
+            t.Fatalf("%v: indexed match %v, full scan %v", location, *indexed, *scanned)
+        }
+    }
+}
+
+func TestSmartDatabaseSizeLevelsOff(t *testing.T) {
+    m, locations := testMap(300, 1500, 10)
+    f := newFingerprinting(false, true, true)
+    for _, location := range locations {
+        f.feed(m.Read(location), location)
+    }
+
+    random := rand.New(rand.NewSource(45))
+    var sizes []int
+    for cycle := 0; cycle < 12; cycle++ {
+        for _, location := range locations {
+            f.read(m.Read(NewLocation(location.X + random.Float64() * 10 - 5, location.Y + random.Float64() * 10 - 5)), location)
+        }
+        sizes = append(sizes, f.databaseSize())
+    }
+
+    cells := int(math.Ceil(300 / defaultCapacityCell)) + 1
+    if limit := cells * cells * defaultCapacity; sizes[len(sizes)-1] > limit {
+        t.Fatalf("database holds %d fingerprints, more than the capacity of %d", sizes[len(sizes)-1], limit)
+    }
+    first := sizes[1] - sizes[0]
+    last := sizes[len(sizes)-1] - sizes[len(sizes)-2]
+    if last * 4 > first {
+        t.Fatalf("database grew by %d fingerprints in the last cycle and %d in the second, sizes %v", last, first, sizes)
+    }
+}
+
+func TestFingerprintingWithShadowing(t *testing.T) {
+    m, locations := testMap(400, 1500, 10)
+    m.EnableShadowing(rand.New(rand.NewSource(46)), 8, defaultDecorrelationDistance)
+    algorithms := []*fingerprinting{
+        newFingerprinting(false, false, false),
+        newFingerprinting(false, true, false),
+        newFingerprinting(false, true, true),
+        newWKNNFingerprinting(4, EuclideanDistance, -100, false),
+        newRankFingerprinting(4, SpearmanDistance, false),
+    }
+
+    longest := 0
+    for _, location := range locations {
+        signals := m.Read(location)
+        if len(signals) > longest {
+            longest = len(signals)
+        }
+        for _, f := range algorithms {
+            reading := make(Signals, len(signals))
+            copy(reading, signals)
+            f.feed(reading, location)
+        }
+    }
+    if longest <= keyLength {
+        t.Fatalf("readings hold at most %d access points, no more than a key", longest)
+    }
+
+    for _, location := range locations[:100] {
+        signals := m.Read(location)
+        for i, f := range algorithms {
+            reading := make(Signals, len(signals))
+            copy(reading, signals)
+            if _, success := f.read(reading, location); !success {
+                t.Fatalf("algorithm %d has no estimate at %v", i, location)
+            }
+        }
+    }
+}
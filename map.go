@@ -7,6 +7,7 @@ import (
     "sort"
     "time"
     "os"
+    "path/filepath"
     "image"
     "image/color"
     "image/draw"
@@ -15,15 +16,6 @@ import (
     "github.com/ruphin/go-gnuplot/pkg/gnuplot"
 )
 
-func init() {
-    rand.Seed(time.Now().UTC().UnixNano())
-    random = rand.New(rand.NewSource(rand.Int63()))
-    log10 = math.Log(10)
-}
-
-var random *rand.Rand
-var log10 float64
-
 //////////////
 // Location //
 //////////////
@@ -36,7 +28,7 @@ func NewLocation(x, y float64) *Location {
     return &Location{x, y}
 }
 
-func NewRandomLocation(xmax, ymax float64) *Location {
+func NewRandomLocation(random *rand.Rand, xmax, ymax float64) *Location {
     return NewLocation(random.Float64()*xmax, random.Float64()*ymax)
 }
 
@@ -50,11 +42,6 @@ func (l *Location) String() string {
     return "(" + fmt.Sprintf("%.2f", l.X) + ", " + fmt.Sprintf("%.2f", l.Y) + ")"
 }
 
-func (l *Location) enhance(realLocation *Location) {
-    l.X = l.X + ((realLocation.X - l.X) * 0.1)
-    l.Y = l.Y + ((realLocation.Y - l.Y) * 0.1)
-}
-
 //////////////////
 // Access Point //
 //////////////////
@@ -62,13 +49,21 @@ func (l *Location) enhance(realLocation *Location) {
 type AccessPoint struct {
     id int
     location *Location
+    // The number of cycles the access point remains switched off
+    offline int
+    radio Radio
+    // The shadow fading around the access point, generated when it is first received
+    shadowing *shadowingField
 }
 
-var accessPointCount int
+func NewAccessPoint(id int, location *Location) *AccessPoint {
+    return &AccessPoint{id, location, 0, DefaultRadio(), nil}
+}
 
-func NewAccessPoint(location *Location) *AccessPoint {
-    accessPointCount += 1
-    return &AccessPoint{accessPointCount, location}
+// Returns the median signal strength of the access point at the given location
+func (ap *AccessPoint) medianSignalStrength(location *Location) float64 {
+    direction := math.Atan2(location.Y - ap.location.Y, location.X - ap.location.X)
+    return medianSignalStrength(distance(ap.location, location)) + ap.radio.offset(direction)
 }
 
 func (ap *AccessPoint) String() string {
@@ -82,8 +77,21 @@ func (ap *AccessPoint) String() string {
 type Signal struct {
     id int
     signalStrength float64
+    band int
+    // The kind of transmitter the signal is from. Algorithms cannot tell, this is only used to evaluate them.
+    source int
 }
 
+// Values for the source of a signal
+const (
+    // An access point of the map
+    accessPointSource = iota
+    // A rogue access point that clones the ID of an access point of the map
+    rogueSource
+    // A mobile hotspot
+    hotspotSource
+)
+
 
 /////////////
 // Signals //
@@ -91,6 +99,17 @@ type Signal struct {
 
 type Signals []Signal
 
+// Returns the signals on the given band
+func (signals Signals) Band(band int) Signals {
+    bandSignals := make(Signals, 0, len(signals))
+    for _, signal := range signals {
+        if signal.band == band {
+            bandSignals = append(bandSignals, signal)
+        }
+    }
+    return bandSignals
+}
+
 // Sorting helpers
 type BySignalStrength Signals
 func (signals BySignalStrength) Len() int           { return len(signals) }
@@ -102,6 +121,49 @@ func (signals ByID) Len() int           { return len(signals) }
 func (signals ByID) Swap(i, j int)      { signals[i], signals[j] = signals[j], signals[i] }
 func (signals ByID) Less(i, j int) bool { return signals[i].id < signals[j].id }
 
+// Returns the signals with only the strongest signal of every ID, as a receiver lists every ID once
+func (signals Signals) distinct() Signals {
+    strongest := make(map[int]int, len(signals))
+    distinct := make(Signals, 0, len(signals))
+    for _, signal := range signals {
+        if i, exists := strongest[signal.id]; exists {
+            if signal.signalStrength > distinct[i].signalStrength {
+                distinct[i] = signal
+            }
+            continue
+        }
+        strongest[signal.id] = len(distinct)
+        distinct = append(distinct, signal)
+    }
+    return distinct
+}
+
+// Returns the signals without the signals of the given access point IDs
+func (signals Signals) without(ids []int) Signals {
+    removed := make(map[int]bool, len(ids))
+    for _, id := range ids {
+        removed[id] = true
+    }
+    remaining := make(Signals, 0, len(signals))
+    for _, signal := range signals {
+        if !removed[signal.id] {
+            remaining = append(remaining, signal)
+        }
+    }
+    return remaining
+}
+
+// Returns the count strongest signals. When there are no more than count signals, they are returned as they are.
+func (signals Signals) strongest(count int) Signals {
+    if len(signals) <= count {
+        return signals
+    }
+    strongest := make(Signals, len(signals))
+    copy(strongest, signals)
+    sort.Sort(BySignalStrength(strongest))
+    return strongest[:count]
+}
+
 // Key() returns a Key object that reprsents the IDs of the signals
 func (signals Signals) Key() Key {
     if len(signals) > keyLength {
@@ -130,24 +192,74 @@ type Key [keyLength]int
 // Map //
 /////////
 
+// A Map holds all of its state, including the random streams it draws from,
+// so separate maps can be used from separate goroutines.
+// A single Map is not safe for concurrent use.
 type Map struct {
     width, height float64
     accessPoints []AccessPoint
+    // The ID of the most recently added access point. IDs are unique within a map.
+    lastID int
+    // Random streams for signal strength noise, for whether signals are received, and for the scan losses of devices
+    noise, dropout, devices *rand.Rand
+    // The shadow fading model, or nil when there is no shadow fading
+    shadowing *shadowing
+    // The simulated time in seconds
+    time float64
+    // Temporal noise and body shadowing, or nil when they are disabled
+    temporal *temporalNoise
+    body *bodyShadowing
+    // Rogue access points that clone the ID of another access point, and mobile hotspots.
+    // They are not part of the access points of the map, so they are never replaced, and their locations are never learned.
+    clones []AccessPoint
+    hotspots []AccessPoint
+}
+
+func NewMap(width, height float64, noise, dropout *rand.Rand) *Map {
+    return &Map{width, height, []AccessPoint{}, 0, noise, dropout, dropout, nil, 0, nil, nil, nil, nil}
+}
+
+// Draws the scan losses of devices from the given random stream. By default they are drawn from the stream for whether
+// signals are received, so using devices changes which signals later readings receive.
+func (m *Map) SetDeviceRandom(random *rand.Rand) {
+    m.devices = random
+}
+
+// Adds spatially correlated shadow fading with the given standard deviation in dB and decorrelation distance in m
+// to the signals of all access points. Fields are drawn from the given random stream.
+func (m *Map) EnableShadowing(random *rand.Rand, deviation, decorrelation float64) {
+    m.shadowing = &shadowing{random, deviation, decorrelation}
 }
 
-func NewMap(width, height float64, source int64) *Map {
-    if source != 0 {
-        random = rand.New(rand.NewSource(source))
+// Adds temporally correlated noise with the given standard deviation in dB and coherence time in seconds
+// to the signals of all access points. The noise is drawn from the given random stream.
+func (m *Map) EnableTemporalNoise(random *rand.Rand, deviation, coherence float64) {
+    m.temporal = newTemporalNoise(random, deviation, coherence)
+}
+
+// Adds body shadowing events at the given rate per second, which attenuate access points by the given loss in dB
+// for a mean duration in seconds. The events are drawn from the given random stream.
+func (m *Map) EnableBodyShadowing(random *rand.Rand, rate, loss, duration float64) {
+    m.body = newBodyShadowing(random, rate, loss, duration)
+}
+
+// Advances the simulated time of the map by the given number of seconds
+func (m *Map) Advance(seconds float64) {
+    m.time += seconds
+    if m.body != nil {
+        m.body.advance(m.time, seconds)
     }
-    return &Map{width, height, []AccessPoint{}}
 }
 
-func (m *Map) AddAccessPoint(location *Location) {
-    m.accessPoints = append(m.accessPoints, *NewAccessPoint(location))
+// Adds an access point at the given location, and returns its ID
+func (m *Map) AddAccessPoint(location *Location) int {
+    m.lastID += 1
+    m.accessPoints = append(m.accessPoints, *NewAccessPoint(m.lastID, location))
+    return m.lastID
 }
 
-func (m *Map) AddRandomAccessPoint() {
-    m.AddAccessPoint(NewRandomLocation(m.width, m.height))
+func (m *Map) AddRandomAccessPoint(random *rand.Rand) int {
+    return m.AddAccessPoint(NewRandomLocation(random, m.width, m.height))
 }
 
 func (m *Map) RemoveAccessPoint(id int) {
@@ -159,17 +271,167 @@ func (m *Map) RemoveAccessPoint(id int) {
     }
 }
 
+// Sets the radio of the access point with the given ID
+func (m *Map) SetRadio(id int, radio Radio) {
+    for i, v := range m.accessPoints {
+        if v.id == id {
+            m.accessPoints[i].radio = radio
+            m.accessPoints[i].shadowing = nil
+            break
+        }
+    }
+}
+
+// Moves the access point with the given ID to a new location. The access point keeps its ID.
+func (m *Map) MoveAccessPoint(id int, location *Location) {
+    for i, v := range m.accessPoints {
+        if v.id == id {
+            // The access point is in a new environment, with new shadow fading
+            m.accessPoints[i].location = location
+            m.accessPoints[i].shadowing = nil
+            break
+        }
+    }
+}
+
+// Moves a random access point in a random direction over the given distance, staying within the map. Returns its ID.
+func (m *Map) RelocateRandomAccessPoint(random *rand.Rand, distance float64) int {
+    accessPoint := m.accessPoints[random.Intn(len(m.accessPoints))]
+    angle := 2 * math.Pi * random.Float64()
+    x := math.Max(0, math.Min(m.width, accessPoint.location.X + distance * math.Cos(angle)))
+    y := math.Max(0, math.Min(m.height, accessPoint.location.Y + distance * math.Sin(angle)))
+    m.MoveAccessPoint(accessPoint.id, NewLocation(x, y))
+    return accessPoint.id
+}
+
+// Removes the count access points closest to the location, and returns the distance to the farthest one removed
+func (m *Map) RemoveNearestAccessPoints(location *Location, count int) float64 {
+    if count > len(m.accessPoints) {
+        count = len(m.accessPoints)
+    }
+    distances := make([]float64, len(m.accessPoints))
+    for i, ap := range m.accessPoints {
+        distances[i] = distance(ap.location, location)
+    }
+    sorted := make([]float64, len(distances))
+    copy(sorted, distances)
+    sort.Float64s(sorted)
+    if count == 0 {
+        return 0
+    }
+    radius := sorted[count-1]
+
+    remaining := m.accessPoints[:0]
+    removed := 0
+    for i, ap := range m.accessPoints {
+        if distances[i] <= radius && removed < count {
+            removed += 1
+            continue
+        }
+        remaining = append(remaining, ap)
+    }
+    m.accessPoints = remaining
+    return radius
+}
+
+// Adds an access point at a uniformly random location within radius of the center, within the map. Returns its ID.
+func (m *Map) AddAccessPointNear(random *rand.Rand, center *Location, radius float64) int {
+    for {
+        // Uniform in a disc, retried when it falls outside the map
+        r := radius * math.Sqrt(random.Float64())
+        angle := 2 * math.Pi * random.Float64()
+        location := NewLocation(center.X + r * math.Cos(angle), center.Y + r * math.Sin(angle))
+        if location.X >= 0 && location.X <= m.width && location.Y >= 0 && location.Y <= m.height {
+            return m.AddAccessPoint(location)
+        }
+    }
+}
+
+// Switches off a random access point that is currently on for the given number of cycles. It keeps its ID.
+// Returns the ID of the access point, or 0 if all access points are off.
+func (m *Map) SwitchOffRandomAccessPoint(random *rand.Rand, cycles int) int {
+    var online []int
+    for i, ap := range m.accessPoints {
+        if ap.offline == 0 {
+            online = append(online, i)
+        }
+    }
+    if len(online) == 0 {
+        return 0
+    }
+    i := online[random.Intn(len(online))]
+    m.accessPoints[i].offline = cycles
+    return m.accessPoints[i].id
+}
+
+// Counts down one cycle for every access point that is switched off
+func (m *Map) AdvanceOutages() {
+    for i, ap := range m.accessPoints {
+        if ap.offline > 0 {
+            m.accessPoints[i].offline -= 1
+        }
+    }
+}
+
 func (m *Map) RemoveOldestAccessPoint() {
     m.accessPoints = m.accessPoints[1:]
 }
 
-func (m *Map) RemoveRandomAccessPoint() int {
+func (m *Map) RemoveRandomAccessPoint(random *rand.Rand) int {
     i := random.Intn(len(m.accessPoints))
     id := m.accessPoints[i].id
     m.accessPoints = append(m.accessPoints[:i], m.accessPoints[i+1:]...)
     return id
 }
 
+// Adds a rogue access point at the given location that broadcasts the ID and radio of the access point with the given ID
+func (m *Map) AddRogueAccessPoint(id int, location *Location) {
+    rogue := NewAccessPoint(id, location)
+    for _, ap := range m.accessPoints {
+        if ap.id == id {
+            rogue.radio = ap.radio
+            break
+        }
+    }
+    m.clones = append(m.clones, *rogue)
+}
+
+// Adds a rogue access point with a random location that broadcasts the ID of a random access point. Returns the ID.
+func (m *Map) AddRandomRogueAccessPoint(random *rand.Rand) int {
+    id := m.accessPoints[random.Intn(len(m.accessPoints))].id
+    m.AddRogueAccessPoint(id, NewRandomLocation(random, m.width, m.height))
+    return id
+}
+
+// Adds a mobile hotspot at the given location, and returns its ID
+func (m *Map) AddMobileHotspot(location *Location) int {
+    m.lastID += 1
+    m.hotspots = append(m.hotspots, *NewAccessPoint(m.lastID, location))
+    return m.lastID
+}
+
+// Moves every mobile hotspot to a random location
+func (m *Map) MoveMobileHotspots(random *rand.Rand) {
+    for i, _ := range m.hotspots {
+        m.hotspots[i].location = NewRandomLocation(random, m.width, m.height)
+        m.hotspots[i].shadowing = nil
+    }
+}
+
+// Returns whether there are rogue access points or mobile hotspots on the map
+func (m *Map) rogues() bool {
+    return len(m.clones) > 0 || len(m.hotspots) > 0
+}
+
+// Returns the real location of every access point on the map, by ID
+func (m *Map) accessPointLocations() map[int]*Location {
+    locations := make(map[int]*Location, len(m.accessPoints))
+    for _, ap := range m.accessPoints {
+        locations[ap.id] = ap.location
+    }
+    return locations
+}
+
 func (m *Map) String() string {
     s := "Map [" + fmt.Sprint(m.width) + " x " + fmt.Sprint(m.height) + "]\n{"
     for _, ap := range m.accessPoints {
@@ -178,24 +440,80 @@ func (m *Map) String() string {
     return s + "}"
 }
 
-// Returns a slice of Signals that are read at the given location.
+// Returns a slice of Signals that are read at the given location by an ideal receiver.
+// Every signal carries the band of its access point.
 func (m *Map) Read(location *Location) Signals {
-    var dist float64
-    signals := make(Signals, 0, len(m.accessPoints))
-    for _, ap := range m.accessPoints {
-        dist = distance(ap.location, location)
-        if signalReceived(dist) {
-            signals = signals[0:len(signals)+1]
-            signals[len(signals)-1] = Signal{ap.id, signalStrength(dist)}
-        }
+    return m.ReadDevice(location, nil)
+}
+
+// Returns a slice of Signals that are read at the given location by the given device, in the order of their IDs.
+// When a rogue access point clones the ID of another access point, only the strongest of their signals is read.
+func (m *Map) ReadDevice(location *Location, device *Device) Signals {
+    signals := make(Signals, 0, len(m.accessPoints) + len(m.clones) + len(m.hotspots))
+    for i, _ := range m.accessPoints {
+        signals = m.receive(signals, &m.accessPoints[i], location, device, accessPointSource)
     }
+    for i, _ := range m.clones {
+        signals = m.receive(signals, &m.clones[i], location, device, rogueSource)
+    }
+    for i, _ := range m.hotspots {
+        signals = m.receive(signals, &m.hotspots[i], location, device, hotspotSource)
+    }
+    if m.rogues() {
+        // Signals are read in the order of their IDs, like the signals of the access points
+        signals = signals.distinct()
+        sort.Sort(ByID(signals))
+    }
+    signals = device.report(m.devices, signals)
     trimmedSignals := make(Signals, len(signals))
     copy(trimmedSignals, signals)
     return trimmedSignals
 }
 
+// Appends the signal of the access point to the signals when it is received at the given location by the given device
+func (m *Map) receive(signals Signals, ap *AccessPoint, location *Location, device *Device, source int) Signals {
+    if ap.offline > 0 {
+        return signals
+    }
+    rss := ap.medianSignalStrength(location)
+    if (m.shadowing != nil || m.temporal != nil) && distance(ap.location, location) <= m.reach(ap) {
+        if m.shadowing != nil {
+            if ap.shadowing == nil {
+                ap.shadowing = m.shadowing.field()
+            }
+            rss += ap.shadowing.at(location)
+        }
+        if m.temporal != nil {
+            rss += m.temporal.at(device.receiver(), ap.id, m.time)
+        }
+    }
+    if m.body != nil {
+        rss -= m.body.attenuation(location, ap.location)
+    }
+    // Stronger access points are received as if they were closer
+    if signalReceived(m.dropout, referenceDistance(rss)) {
+        signals = append(signals, Signal{ap.id, noisySignalStrength(m.noise, rss), ap.radio.Band, source})
+    }
+    return signals
+}
+
+// Returns the distance in m beyond which signals of the access point are not received, even with shadow fading and temporal noise
+func (m *Map) reach(ap *AccessPoint) float64 {
+    var variance float64
+    if m.shadowing != nil {
+        variance += m.shadowing.deviation * m.shadowing.deviation
+    }
+    if m.temporal != nil {
+        variance += m.temporal.deviation * m.temporal.deviation
+    }
+    // The strongest signal the access point can produce, relative to the reference access point
+    peak := ap.radio.TransmitPower - referenceTransmitPower + ap.radio.AntennaGain - bandLoss[ap.radio.Band]
+    return referenceDistance(medianSignalStrength(maxReceptionDistance) - peak - receptionMargin * math.Sqrt(variance))
+}
 
-func (m *Map) Draw(accessPointCutoffs []int) {
+// Draws the access points on the map to a png image in the given directory.
+// Access points are colored by the generation they belong to, as given by the highest ID in every generation.
+func (m *Map) Draw(directory string, accessPointCutoffs []int) {
     width := int(m.width) + 5
     height := int(m.height) + 5
     mapImage := image.NewRGBA(image.Rect(0,0,width,height))
@@ -235,10 +553,15 @@ func (m *Map) Draw(accessPointCutoffs []int) {
             }
         }
     }
-    if os.MkdirAll("maps", 0777) != nil {
+    if os.MkdirAll(directory, 0777) != nil {
         panic("** err: Unable to create directory for maps")
     }
-    image, _ := os.Create("maps/map" + strconv.Itoa(len(accessPointCutoffs)) + ".png")
+    image, err := os.Create(filepath.Join(directory, "map" + strconv.Itoa(len(accessPointCutoffs)) + ".png"))
+    if err != nil {
+        err_string := fmt.Sprintf("** err: %v\n", err)
+        panic(err_string)
+    }
+    defer image.Close()
 
     png.Encode(image, mapImage)
 }
@@ -247,13 +570,33 @@ func (m *Map) Draw(accessPointCutoffs []int) {
 // HELPER FUNCTIONS //
 //////////////////////
 
-func signalReceived(distance float64) bool {
+// The distance in m beyond which signals of the reference access point are never received
+var maxReceptionDistance = 64 * (math.Exp(0.6) - 0.5)
+
+// The number of standard deviations of shadow fading and temporal noise that is added to the reach of an access point.
+// Beyond its reach, signals of the access point are not received, so it has no shadow fading or temporal noise.
+const receptionMargin = 3.0
+
+func signalReceived(random *rand.Rand, distance float64) bool {
     return random.Float64() < 0.6 - math.Log(distance/64 + 0.5)
 }
 
-func signalStrength(distance float64) float64 {
-    rss := -58 - (14 * math.Log(distance + 5) / log10)
+// Returns the median signal strength of the reference access point at the given distance
+func medianSignalStrength(distance float64) float64 {
+    return -58 - (14 * math.Log(distance + 5) / math.Ln10)
+}
+
+// Returns the distance at which the reference access point has the given median signal strength
+func referenceDistance(rss float64) float64 {
+    return math.Max(0, math.Pow(10, (-58 - rss) / 14) - 5)
+}
+
+func signalStrength(random *rand.Rand, distance float64) float64 {
+    return noisySignalStrength(random, medianSignalStrength(distance))
+}
 
+// Returns the median signal strength with noise added
+func noisySignalStrength(random *rand.Rand, rss float64) float64 {
     stddev := 0.0497 * rss + 6.3438
     theta := 2 * math.Pi * random.Float64()
     rho := math.Sqrt(-2 * math.Log(1 - random.Float64()))
@@ -262,6 +605,7 @@ func signalStrength(distance float64) float64 {
 }
 
 func Test() {
+    random := rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
     distances := []float64{5,10,15,20,25,30,35,40,45,50,55,60,65,70,75,80,85,90,95,100}
     results := make([]map[int]float64, len(distances))
     for i, _ := range results {
@@ -270,8 +614,8 @@ func Test() {
     testSize := 1000000
     for i := 0; i < testSize; i++ {
         for i, distance := range distances {
-            if signalReceived(distance) {
-                results[i][int(signalStrength(distance))]++
+            if signalReceived(random, distance) {
+                results[i][int(signalStrength(random, distance))]++
             }
         }
     }
@@ -359,7 +703,7 @@ func graphSignalStrength() {
     signalStrengths := make([]float64, 125)
     byDistance := make([]float64, 125)
     for i := 5; i < 125; i++ {
-        signalStrengths[i] = -58 - (14 * math.Log(float64(i) + 5) / log10)
+        signalStrengths[i] = -58 - (14 * math.Log(float64(i) + 5) / math.Ln10)
         byDistance[i] = float64(i)
     }
     fmt.Println(byDistance)
@@ -0,0 +1,174 @@
+package wifi
+
+import (
+    "fmt"
+    "image"
+    "math"
+    "math/rand"
+    "os"
+    "sort"
+)
+
+// A placement generates the locations of new access points on a map
+type placement interface {
+    location(random *rand.Rand) *Location
+}
+
+// Creates the placement selected in the configuration.
+// Random choices that are fixed for the whole simulation, such as cluster centers, are drawn from the given stream.
+func newPlacement(config *Configuration, random *rand.Rand) placement {
+    width, height := config.MapWidth, config.MapHeight
+    switch config.Placement {
+    case ThomasPlacement, MaternPlacement:
+        count := float64(config.accessPointCount())
+        parents := make([]*Location, int(math.Ceil(count / config.ClusterSize)))
+        for i, _ := range parents {
+            parents[i] = NewRandomLocation(random, width, height)
+        }
+        return &clusterPlacement{width, height, parents, config.ClusterRadius, config.Placement == ThomasPlacement}
+    case GridPlacement:
+        spacing := config.gridSpacing()
+        columns, rows := int(width / spacing), int(height / spacing)
+        return &gridPlacement{width, height, spacing, config.GridJitter, columns, rows, random.Perm(columns * rows), 0}
+    case RasterPlacement:
+        return newRasterPlacement(width, height, config.DensityRaster)
+    default:
+        return &uniformPlacement{width, height}
+    }
+}
+
+// Returns true if the location lies on a map of the given size
+func onMap(location *Location, width, height float64) bool {
+    return location.X >= 0 && location.X <= width && location.Y >= 0 && location.Y <= height
+}
+
+////////////////////////
+// Uniform Placement //
+////////////////////////
+
+type uniformPlacement struct {
+    width, height float64
+}
+
+func (p *uniformPlacement) location(random *rand.Rand) *Location {
+    return NewRandomLocation(random, p.width, p.height)
+}
+
+////////////////////////
+// Cluster Placement //
+////////////////////////
+
+// Places access points around a fixed set of cluster centers, as in a Neyman-Scott process.
+// A Thomas process offsets access points from their center with a normal distribution, a Matérn process places them uniformly in a disc.
+type clusterPlacement struct {
+    width, height float64
+    parents []*Location
+    radius float64
+    thomas bool
+}
+
+func (p *clusterPlacement) location(random *rand.Rand) *Location {
+    for {
+        parent := p.parents[random.Intn(len(p.parents))]
+        var dx, dy float64
+        if p.thomas {
+            dx = random.NormFloat64() * p.radius
+            dy = random.NormFloat64() * p.radius
+        } else {
+            r := p.radius * math.Sqrt(random.Float64())
+            angle := 2 * math.Pi * random.Float64()
+            dx = r * math.Cos(angle)
+            dy = r * math.Sin(angle)
+        }
+        location := NewLocation(parent.X + dx, parent.Y + dy)
+        if onMap(location, p.width, p.height) {
+            return location
+        }
+    }
+}
+
+/////////////////////
+// Grid Placement //
+/////////////////////
+
+// Places access points on a regular grid, as ceiling mounted access points are, with a normally distributed jitter.
+// The grid locations are used in a random order, so a grid with more locations than access points is covered evenly.
+// Once every grid location is used, access points are placed on random grid locations.
+type gridPlacement struct {
+    width, height float64
+    spacing, jitter float64
+    columns, rows int
+    order []int
+    next int
+}
+
+func (p *gridPlacement) location(random *rand.Rand) *Location {
+    var i int
+    if p.next < len(p.order) {
+        i = p.order[p.next]
+        p.next += 1
+    } else {
+        i = random.Intn(p.columns * p.rows)
+    }
+    for {
+        x := (float64(i % p.columns) + 0.5) * p.spacing + random.NormFloat64() * p.jitter
+        y := (float64(i / p.columns) + 0.5) * p.spacing + random.NormFloat64() * p.jitter
+        location := NewLocation(x, y)
+        if onMap(location, p.width, p.height) {
+            return location
+        }
+    }
+}
+
+///////////////////////
+// Raster Placement //
+///////////////////////
+
+// Places access points with a density proportional to the brightness of the pixels of an image, which is stretched over the map
+type rasterPlacement struct {
+    width, height float64
+    columns, rows int
+    // The cumulative brightness of the pixels, row by row
+    cumulative []float64
+}
+
+func newRasterPlacement(width, height float64, path string) *rasterPlacement {
+    file, err := os.Open(path)
+    if err != nil {
+        err_string := fmt.Sprintf("** err: %v\n", err)
+        panic(err_string)
+    }
+    defer file.Close()
+    raster, _, err := image.Decode(file)
+    if err != nil {
+        err_string := fmt.Sprintf("** err: Unable to decode density raster: %v\n", err)
+        panic(err_string)
+    }
+
+    bounds := raster.Bounds()
+    p := &rasterPlacement{width, height, bounds.Dx(), bounds.Dy(), make([]float64, bounds.Dx() * bounds.Dy())}
+    var total float64
+    for y := 0; y < p.rows; y++ {
+        for x := 0; x < p.columns; x++ {
+            r, g, b, _ := raster.At(bounds.Min.X + x, bounds.Min.Y + y).RGBA()
+            total += 0.299 * float64(r) + 0.587 * float64(g) + 0.114 * float64(b)
+            p.cumulative[y * p.columns + x] = total
+        }
+    }
+    if total == 0 {
+        err_string := "** err: Density raster is completely black"
+        panic(err_string)
+    }
+    return p
+}
+
+func (p *rasterPlacement) location(random *rand.Rand) *Location {
+    target := random.Float64() * p.cumulative[len(p.cumulative)-1]
+    i := sort.SearchFloat64s(p.cumulative, target)
+    if i == len(p.cumulative) {
+        i -= 1
+    }
+    x := (float64(i % p.columns) + random.Float64()) * p.width / float64(p.columns)
+    y := (float64(i / p.columns) + random.Float64()) * p.height / float64(p.rows)
+    return NewLocation(x, y)
+}
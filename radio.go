@@ -0,0 +1,58 @@
+package wifi
+
+import (
+    "math"
+)
+
+// Frequency bands
+const (
+    Band24 = 1
+    Band5 = 2
+    Band6 = 3
+)
+
+// The additional free space path loss in dB of every band, relative to 2.4 GHz
+var bandLoss = map[int]float64{Band24: 0, Band5: 7.1, Band6: 8.5}
+
+// The transmit power in dBm of the reference access point that the signal strength model describes
+const referenceTransmitPower = 20.0
+
+///////////
+// Radio //
+///////////
+
+// The radio properties of an access point
+type Radio struct {
+    // Transmit power in dBm
+    TransmitPower float64
+    // Peak antenna gain in dBi
+    AntennaGain float64
+    // The direction in radians a directional antenna points in, and its 3 dB beamwidth in radians.
+    // The antenna is omnidirectional when the beamwidth is 0.
+    Azimuth, Beamwidth float64
+    // The attenuation in dB of a directional antenna towards the back
+    FrontToBack float64
+    // Band24, Band5 or Band6
+    Band int
+}
+
+// Returns the radio of the reference access point, an omnidirectional 20 dBm access point on the 2.4 GHz band
+func DefaultRadio() Radio {
+    return Radio{referenceTransmitPower, 0, 0, 0, 0, Band24}
+}
+
+// Returns the difference in dB between the signal strength of this radio and the reference access point,
+// for a receiver in the given direction in radians
+func (r Radio) offset(direction float64) float64 {
+    return r.TransmitPower - referenceTransmitPower + r.AntennaGain + r.patternGain(direction) - bandLoss[r.Band]
+}
+
+// Returns the gain in dB of the antenna pattern in the given direction, relative to the peak gain.
+// Directional antennas follow the parabolic pattern -min(12 (angle / beamwidth)^2, front to back ratio).
+func (r Radio) patternGain(direction float64) float64 {
+    if r.Beamwidth == 0 {
+        return 0
+    }
+    angle := math.Abs(math.Remainder(direction - r.Azimuth, 2 * math.Pi))
+    return -math.Min(12 * (angle / r.Beamwidth) * (angle / r.Beamwidth), r.FrontToBack)
+}
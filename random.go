@@ -0,0 +1,67 @@
+package wifi
+
+import (
+    "math"
+    "math/rand"
+)
+
+// randomStreams holds a separate random number generator for every random process in a simulation.
+// Every stream is seeded from the same master seed, so a simulation is reproducible from that seed,
+// while changing how many numbers one process draws does not change the numbers drawn by the others.
+type randomStreams struct {
+    // Locations of the initial access points
+    layout *rand.Rand
+    // Which access points are replaced, and the locations of their replacements
+    replacement *rand.Rand
+    // Signal strength noise
+    noise *rand.Rand
+    // Whether signals are received
+    dropout *rand.Rand
+    // The order of the test locations
+    shuffle *rand.Rand
+    // Shadow fading fields of access points
+    shadowing *rand.Rand
+    // Temporal noise
+    temporal *rand.Rand
+    // Rogue access points, mobile hotspots and poisoned readings
+    adversary *rand.Rand
+    // Body shadowing events
+    body *rand.Rand
+    // Scan losses of devices
+    devices *rand.Rand
+}
+
+func newRandomStreams(seed int64) *randomStreams {
+    master := rand.New(rand.NewSource(seed))
+    return &randomStreams{
+        layout: rand.New(rand.NewSource(master.Int63())),
+        replacement: rand.New(rand.NewSource(master.Int63())),
+        noise: rand.New(rand.NewSource(master.Int63())),
+        dropout: rand.New(rand.NewSource(master.Int63())),
+        shuffle: rand.New(rand.NewSource(master.Int63())),
+        shadowing: rand.New(rand.NewSource(master.Int63())),
+        temporal: rand.New(rand.NewSource(master.Int63())),
+        adversary: rand.New(rand.NewSource(master.Int63())),
+        body: rand.New(rand.NewSource(master.Int63())),
+        devices: rand.New(rand.NewSource(master.Int63())),
+    }
+}
+
+// Returns a number drawn from a Poisson distribution with the given mean
+func poisson(random *rand.Rand, mean float64) int {
+    if mean <= 0 {
+        return 0
+    }
+    // For large means the normal approximation is accurate, and much faster
+    if mean > 30 {
+        return int(math.Max(0, math.Floor(mean + random.NormFloat64() * math.Sqrt(mean) + 0.5)))
+    }
+    limit := math.Exp(-mean)
+    count := 0
+    product := random.Float64()
+    for product > limit {
+        count += 1
+        product *= random.Float64()
+    }
+    return count
+}
@@ -0,0 +1,91 @@
+package wifi
+
+import (
+    "sort"
+)
+
+// The minimum number of shared access points for their rank order to be compared
+const minRankMatches = 3
+
+// Matches fingerprints on the order of the signal strengths of shared access points, which a device offset does not change.
+// The metric must be SpearmanDistance or KendallDistance.
+func NewRankFingerprinting(k int, metric int, options ...FingerprintingOption) algorithm {
+    return algorithm(newRankFingerprinting(k, metric, false).apply(options))
+}
+
+func NewLearningRankFingerprinting(k int, metric int, options ...FingerprintingOption) algorithm {
+    return algorithm(newRankFingerprinting(k, metric, true).apply(options))
+}
+
+func newRankFingerprinting(k int, metric int, learning bool) *fingerprinting {
+    if metric != SpearmanDistance && metric != KendallDistance {
+        err_string := "** err: Rank Fingerprinting requires SpearmanDistance or KendallDistance"
+        panic(err_string)
+    }
+    return newWKNNFingerprinting(k, metric, 0, learning)
+}
+
+// signals MUST be sorted by ID
+// Returns a distance between 0 and 1 from the rank correlation of the shared access points, weighted by the fraction that is shared
+func (f *fingerprinting) rankDistance(signals1, signals2 Signals) float64 {
+    var strengths1, strengths2 []float64
+    var i1, i2 int = 0, 0
+    for i1 < len(signals1) && i2 < len(signals2) {
+        if signals1[i1].id == signals2[i2].id {
+            strengths1 = append(strengths1, signals1[i1].signalStrength)
+            strengths2 = append(strengths2, signals2[i2].signalStrength)
+            i1 += 1
+            i2 += 1
+        } else if signals1[i1].id < signals2[i2].id {
+            i1 += 1
+        } else {
+            i2 += 1
+        }
+    }
+
+    shared := len(strengths1)
+    overlap := float64(shared) / float64(len(signals1) + len(signals2) - shared)
+
+    // Correlation is 0 when there are too few access points to compare
+    var correlation float64
+    if shared >= minRankMatches {
+        if f.metric == SpearmanDistance {
+            correlation = float64(spearman(ranks(strengths1), ranks(strengths2)))
+        } else {
+            correlation = kendall(strengths1, strengths2)
+        }
+    }
+    return (1 - overlap) + overlap * (1 - correlation) / 2
+}
+
+// Returns the rank of every value, where the strongest signal has rank 1
+func ranks(values []float64) []int {
+    order := make([]int, len(values))
+    for i := range order {
+        order[i] = i
+    }
+    sort.SliceStable(order, func(a, b int) bool { return values[order[a]] > values[order[b]] })
+
+    ranks := make([]int, len(values))
+    for rank, i := range order {
+        ranks[i] = rank + 1
+    }
+    return ranks
+}
+
+// Returns the Kendall tau rank correlation between two equal length lists of values
+func kendall(values1, values2 []float64) float64 {
+    n := len(values1)
+    var concordant, discordant int
+    for i := 0; i < n; i++ {
+        for j := i + 1; j < n; j++ {
+            product := (values1[i] - values1[j]) * (values2[i] - values2[j])
+            if product > 0 {
+                concordant += 1
+            } else if product < 0 {
+                discordant += 1
+            }
+        }
+    }
+    return float64(concordant - discordant) / float64(n * (n - 1) / 2)
+}
@@ -0,0 +1,98 @@
+package wifi
+
+import (
+    "math"
+    "math/rand"
+)
+
+////////////
+// Region //
+////////////
+
+// A Region is a named area of the map for which results are evaluated and plotted separately
+type Region struct {
+    Name string
+    // The corners of the region in order
+    Vertices []*Location
+}
+
+func NewRectangleRegion(name string, minX, minY, maxX, maxY float64) *Region {
+    return NewPolygonRegion(name, NewLocation(minX, minY), NewLocation(maxX, minY), NewLocation(maxX, maxY), NewLocation(minX, maxY))
+}
+
+func NewPolygonRegion(name string, vertices ...*Location) *Region {
+    return &Region{name, vertices}
+}
+
+// Returns true if the location lies inside the region. Locations on the edge of a rectangle are inside.
+func (r *Region) contains(location *Location) bool {
+    min, max := r.bounds()
+    if location.X < min.X || location.X > max.X || location.Y < min.Y || location.Y > max.Y {
+        return false
+    }
+    if len(r.Vertices) == 4 && r.isRectangle() {
+        return true
+    }
+
+    // Count the edges crossed by a ray from the location in the positive X direction
+    inside := false
+    j := len(r.Vertices) - 1
+    for i, vertex := range r.Vertices {
+        previous := r.Vertices[j]
+        if (vertex.Y > location.Y) != (previous.Y > location.Y) {
+            crossing := vertex.X + (location.Y - vertex.Y) / (previous.Y - vertex.Y) * (previous.X - vertex.X)
+            if location.X < crossing {
+                inside = !inside
+            }
+        }
+        j = i
+    }
+    return inside
+}
+
+// Returns true if the region is an axis aligned rectangle
+func (r *Region) isRectangle() bool {
+    for i, vertex := range r.Vertices {
+        next := r.Vertices[(i + 1) % len(r.Vertices)]
+        if vertex.X != next.X && vertex.Y != next.Y {
+            return false
+        }
+    }
+    return true
+}
+
+// Returns a uniformly random location inside the region
+func (r *Region) randomLocation(random *rand.Rand) *Location {
+    min, max := r.bounds()
+    for {
+        location := NewLocation(min.X + random.Float64() * (max.X - min.X), min.Y + random.Float64() * (max.Y - min.Y))
+        if r.contains(location) {
+            return location
+        }
+    }
+}
+
+// Returns the area of the region, by the shoelace formula
+func (r *Region) area() float64 {
+    var sum float64
+    j := len(r.Vertices) - 1
+    for i, vertex := range r.Vertices {
+        previous := r.Vertices[j]
+        sum += (previous.X - vertex.X) * (previous.Y + vertex.Y)
+        j = i
+    }
+    return math.Abs(sum) / 2
+}
+
+// Returns the corners of the bounding box of the region
+func (r *Region) bounds() (*Location, *Location) {
+    min := NewLocation(math.Inf(1), math.Inf(1))
+    max := NewLocation(math.Inf(-1), math.Inf(-1))
+    for _, vertex := range r.Vertices {
+        min.X = math.Min(min.X, vertex.X)
+        min.Y = math.Min(min.Y, vertex.Y)
+        max.X = math.Max(max.X, vertex.X)
+        max.Y = math.Max(max.Y, vertex.Y)
+    }
+    return min, max
+}
@@ -0,0 +1,38 @@
+package wifi
+
+// relocationDetection notices access points that have been moved, by comparing the location an algorithm has learned
+// for an access point with the location it is observed at. An access point that is observed too far from its learned
+// location a number of times in a row is considered relocated, and its learned data should be reset.
+type relocationDetection struct {
+    // The distance in m beyond which an observation is inconsistent. Detection is disabled when 0.
+    threshold float64
+    // The number of consecutive inconsistent observations after which an access point is relocated
+    count int
+    inconsistent map[int]int
+}
+
+func newRelocationDetection(threshold float64, count int) relocationDetection {
+    if threshold < 0 || count < 0 || (threshold > 0 && count == 0) {
+        err_string := "** err: Relocation detection needs a positive threshold and count"
+        panic(err_string)
+    }
+    return relocationDetection{threshold, count, make(map[int]int)}
+}
+
+func (r *relocationDetection) enabled() bool {
+    return r.threshold > 0
+}
+
+// Records an observation of the access point, and returns true if the access point is considered relocated
+func (r *relocationDetection) relocated(id int, learned, observed *Location) bool {
+    if distance(learned, observed) <= r.threshold {
+        delete(r.inconsistent, id)
+        return false
+    }
+    r.inconsistent[id] += 1
+    if r.inconsistent[id] < r.count {
+        return false
+    }
+    delete(r.inconsistent, id)
+    return true
+}
@@ -0,0 +1,67 @@
+package wifi
+
+import (
+    "math"
+    "math/rand"
+)
+
+// The default distance in m over which the shadow fading of an access point decorrelates
+const defaultDecorrelationDistance = 20.0
+
+// The number of sinusoids the shadow fading field of an access point is the sum of
+const shadowingSinusoids = 32
+
+// A shadowing model describes the shadow fading of all access points on a map
+type shadowing struct {
+    random *rand.Rand
+    // The standard deviation of the shadow fading in dB
+    deviation float64
+    // The distance in m at which the correlation of the shadow fading drops to 1/e
+    decorrelation float64
+}
+
+// Returns a new shadow fading field for an access point
+func (s *shadowing) field() *shadowingField {
+    return newShadowingField(s.random, s.deviation, s.decorrelation)
+}
+
+/////////////////////
+// Shadowing Field //
+/////////////////////
+
+// A shadowingField is a Gaussian random field of shadow fading values in dB, following the Gudmundson model.
+// The field is a sum of sinusoids with random directions and phases, and wave numbers drawn from the spectrum of the
+// correlation exp(-distance / decorrelation), so it takes the same memory at any size.
+type shadowingField struct {
+    // The wave vector and phase of every sinusoid
+    kx, ky, phase []float64
+    amplitude float64
+}
+
+func newShadowingField(random *rand.Rand, deviation, decorrelation float64) *shadowingField {
+    field := &shadowingField{
+        make([]float64, shadowingSinusoids),
+        make([]float64, shadowingSinusoids),
+        make([]float64, shadowingSinusoids),
+        deviation * math.Sqrt(2.0 / shadowingSinusoids),
+    }
+    for n := 0; n < shadowingSinusoids; n++ {
+        // Inverts the distribution of wave numbers, 1 - 1 / sqrt(1 + (k decorrelation)^2)
+        u := random.Float64()
+        k := math.Sqrt(1 / ((1 - u) * (1 - u)) - 1) / decorrelation
+        direction := 2 * math.Pi * random.Float64()
+        field.kx[n] = k * math.Cos(direction)
+        field.ky[n] = k * math.Sin(direction)
+        field.phase[n] = 2 * math.Pi * random.Float64()
+    }
+    return field
+}
+
+// Returns the shadow fading at the given location
+func (field *shadowingField) at(location *Location) float64 {
+    var value float64
+    for n, phase := range field.phase {
+        value += math.Cos(field.kx[n] * location.X + field.ky[n] * location.Y + phase)
+    }
+    return value * field.amplitude
+}
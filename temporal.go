@@ -0,0 +1,111 @@
+package wifi
+
+import (
+    "math"
+    "math/rand"
+)
+
+// The default time in seconds over which temporal noise decorrelates
+const defaultCoherenceTime = 10.0
+
+// The default attenuation in dB and mean duration in seconds of body shadowing events
+const defaultBodyShadowingLoss = 10.0
+const defaultBodyShadowingDuration = 3.0
+
+// The angle in radians around the direction a body shadowing event blocks, within which access points are attenuated
+const bodyShadowingWidth = 2 * math.Pi / 3
+
+////////////////////
+// Temporal Noise //
+////////////////////
+
+// Temporal noise is a first order autoregressive process per access point per receiver, so readings shortly after each other
+// have similar errors. The correlation between two values dt seconds apart is exp(-dt / coherence).
+type temporalNoise struct {
+    random *rand.Rand
+    // The standard deviation of the noise in dB
+    deviation float64
+    // The time in seconds at which the correlation drops to 1/e
+    coherence float64
+    // The most recent value of every access point for every receiver, and the time it was drawn
+    values map[temporalKey]float64
+    updated map[temporalKey]float64
+}
+
+type temporalKey struct {
+    receiver string
+    id int
+}
+
+func newTemporalNoise(random *rand.Rand, deviation, coherence float64) *temporalNoise {
+    return &temporalNoise{random, deviation, coherence, make(map[temporalKey]float64), make(map[temporalKey]float64)}
+}
+
+// Returns the noise of the access point with the given ID for the named receiver at the given time
+func (t *temporalNoise) at(receiver string, id int, now float64) float64 {
+    key := temporalKey{receiver, id}
+    previous, exists := t.values[key]
+    var value float64
+    if !exists {
+        value = t.random.NormFloat64() * t.deviation
+    } else {
+        rho := math.Exp(-(now - t.updated[key]) / t.coherence)
+        value = rho * previous + math.Sqrt(1 - rho * rho) * t.deviation * t.random.NormFloat64()
+    }
+    t.values[key] = value
+    t.updated[key] = now
+    return value
+}
+
+////////////////////
+// Body Shadowing //
+////////////////////
+
+// Body shadowing events occur at random times, and attenuate the access points behind the body of the user for a while
+type bodyShadowing struct {
+    random *rand.Rand
+    // The expected number of events per second
+    rate float64
+    // The attenuation in dB
+    loss float64
+    // The mean duration of an event in seconds
+    duration float64
+    events []bodyShadowingEvent
+}
+
+type bodyShadowingEvent struct {
+    // The direction in radians the body blocks
+    direction float64
+    // The time at which the event ends
+    end float64
+}
+
+func newBodyShadowing(random *rand.Rand, rate, loss, duration float64) *bodyShadowing {
+    return &bodyShadowing{random, rate, loss, duration, nil}
+}
+
+// Ends the events that are over at the given time, and starts the events that occurred in the elapsed seconds before it
+func (b *bodyShadowing) advance(now, elapsed float64) {
+    events := b.events[:0]
+    for _, event := range b.events {
+        if event.end > now {
+            events = append(events, event)
+        }
+    }
+    b.events = events
+    for i := poisson(b.random, b.rate * elapsed); i > 0; i-- {
+        direction := 2 * math.Pi * b.random.Float64()
+        b.events = append(b.events, bodyShadowingEvent{direction, now + b.random.ExpFloat64() * b.duration})
+    }
+}
+
+// Returns the attenuation of the signal of an access point at the given location, for a receiver at the given location
+func (b *bodyShadowing) attenuation(receiver, accessPoint *Location) float64 {
+    direction := math.Atan2(accessPoint.Y - receiver.Y, accessPoint.X - receiver.X)
+    for _, event := range b.events {
+        if math.Abs(math.Remainder(direction - event.direction, 2 * math.Pi)) < bodyShadowingWidth / 2 {
+            return b.loss
+        }
+    }
+    return 0
+}
@@ -0,0 +1,168 @@
+package wifi
+
+import (
+    "math"
+    "sort"
+)
+
+// Distance metrics for weighted k-nearest-neighbour fingerprinting
+const (
+    EuclideanDistance = 1
+    ManhattanDistance = 2
+    CosineDistance = 3
+    // Euclidean distance where every access point is divided by the standard deviation of its signal strengths over the whole database
+    StandardizedDistance = 4
+    // Rank correlation of the signal strengths of shared access points, see rank.go
+    SpearmanDistance = 5
+    KendallDistance = 6
+)
+
+// Estimates the location as the average of the k fingerprints with the closest signal strengths, weighted by their trust
+// divided by their distance. Missing access points get the floor signal strength (e.g. -100 dBm).
+func NewWKNNFingerprinting(k int, metric int, floor float64, options ...FingerprintingOption) algorithm {
+    return algorithm(newWKNNFingerprinting(k, metric, floor, false).apply(options))
+}
+
+func NewLearningWKNNFingerprinting(k int, metric int, floor float64, options ...FingerprintingOption) algorithm {
+    return algorithm(newWKNNFingerprinting(k, metric, floor, true).apply(options))
+}
+
+func newWKNNFingerprinting(k int, metric int, floor float64, learning bool) *fingerprinting {
+    if k < 1 {
+        err_string := "** err: k must be at least 1"
+        panic(err_string)
+    }
+    if metric < EuclideanDistance || metric > KendallDistance {
+        err_string := "** err: Unknown Distance Metric"
+        panic(err_string)
+    }
+    f := newFingerprinting(false, learning, false)
+    f.bestMatches = k
+    f.wknn = true
+    f.metric = metric
+    f.floor = floor
+    return f
+}
+
+// Returns the weighted average location of the k nearest fingerprints that share an access point with the signals, or nil if there are none
+func (f *fingerprinting) nearestNeighbours(signals Signals) *Estimate {
+    if len(signals) == 0 {
+        return nil
+    }
+    var neighbours []stuf
+    for _, key := range f.candidates(signals) {
+        for _, fingerprint := range f.fingerprintMap[key] {
+            neighbours = append(neighbours, stuf{fingerprint.location, f.signalDistance(signals, fingerprint.signals), fingerprint.trust()})
+        }
+    }
+    if len(neighbours) == 0 {
+        return nil
+    }
+
+    sort.Sort(ByDistance(neighbours))
+    if len(neighbours) > f.bestMatches {
+        neighbours = neighbours[:f.bestMatches]
+    }
+    locations := make([]*Location, len(neighbours))
+    weights := make([]float64, len(neighbours))
+    for i, neighbour := range neighbours {
+        locations[i] = neighbour.location
+        weights[i] = neighbour.trust / (neighbour.distance + 1e-9)
+    }
+    location := weightedAverage(locations, weights)
+    return &Estimate{location, spread(locations, weights, location), len(signals), neighbours[0].distance, nil, nil}
+}
+
+// signals MUST be sorted by ID
+// Returns the distance between two lists of signals in the configured metric
+func (f *fingerprinting) signalDistance(signals1, signals2 Signals) float64 {
+    if f.metric == SpearmanDistance || f.metric == KendallDistance {
+        return f.rankDistance(signals1, signals2)
+    }
+
+    var s1, s2, diff float64
+    var id int
+    var sum, dot, norm1, norm2 float64
+    var i1, i2 int = 0, 0
+    for i1 < len(signals1) || i2 < len(signals2) {
+        if i2 == len(signals2) || (i1 < len(signals1) && signals1[i1].id < signals2[i2].id) {
+            id, s1, s2 = signals1[i1].id, signals1[i1].signalStrength, f.floor
+            i1 += 1
+        } else if i1 == len(signals1) || signals2[i2].id < signals1[i1].id {
+            id, s1, s2 = signals2[i2].id, f.floor, signals2[i2].signalStrength
+            i2 += 1
+        } else {
+            id, s1, s2 = signals1[i1].id, signals1[i1].signalStrength, signals2[i2].signalStrength
+            i1 += 1
+            i2 += 1
+        }
+
+        diff = s1 - s2
+        switch f.metric {
+        case EuclideanDistance:
+            sum += diff * diff
+        case ManhattanDistance:
+            sum += math.Abs(diff)
+        case StandardizedDistance:
+            sum += diff * diff / f.variance(id)
+        case CosineDistance:
+            // Signal strengths are measured relative to the floor, so missing access points contribute nothing
+            s1 = math.Max(0, s1 - f.floor)
+            s2 = math.Max(0, s2 - f.floor)
+            dot += s1 * s2
+            norm1 += s1 * s1
+            norm2 += s2 * s2
+        }
+    }
+
+    switch f.metric {
+    case ManhattanDistance:
+        return sum
+    case CosineDistance:
+        if norm1 == 0 || norm2 == 0 {
+            return 1
+        }
+        return 1 - dot / math.Sqrt(norm1 * norm2)
+    default:
+        return math.Sqrt(sum)
+    }
+}
+
+// Running mean and variance of the signal strengths of an access point
+type signalStatistics struct {
+    count int
+    mean, m2 float64
+}
+
+func (f *fingerprinting) signalStatistics(id int) *signalStatistics {
+    statistics, exists := f.statistics[id]
+    if !exists {
+        statistics = &signalStatistics{}
+        f.statistics[id] = statistics
+    }
+    return statistics
+}
+
+// Returns the signal strength variance of an access point, without modifying the statistics
+func (f *fingerprinting) variance(id int) float64 {
+    statistics, exists := f.statistics[id]
+    if !exists {
+        return 1
+    }
+    return statistics.variance()
+}
+
+func (s *signalStatistics) add(signalStrength float64) {
+    s.count += 1
+    delta := signalStrength - s.mean
+    s.mean += delta / float64(s.count)
+    s.m2 += delta * (signalStrength - s.mean)
+}
+
+// Returns the variance of the signal strengths, at least 1 dB^2
+func (s *signalStatistics) variance() float64 {
+    if s.count < 2 {
+        return 1
+    }
+    return math.Max(1, s.m2 / float64(s.count - 1))
+}
@@ -0,0 +1,339 @@
+package wifi
+
+import (
+    "math"
+    "sort"
+)
+
+// The number of samples an access point needs before its location and path loss are estimated
+const minWLSSamples = 4
+
+// The number of iterations of every least squares fit
+const wlsIterations = 10
+
+// The bounds of the estimated path loss exponent
+const minPathLossExponent = 0.5
+const maxPathLossExponent = 6.0
+
+// The largest step in m a single iteration moves a location
+const maxWLSStep = 20.0
+
+// The number of estimated receiver locations a learning algorithm keeps, the number of reads after which it refines
+// the access points and receiver locations together, and the number of iterations of a refinement
+const maxPoses = 2000
+const refineInterval = 200
+const refineIterations = 3
+
+//////////////////////////////////
+// Weighted Least Squares (WLS) //
+//////////////////////////////////
+
+// A wlsSample is the signal strength of an access point at a location. Samples at surveyed locations have no pose,
+// samples at estimated locations take their location from the pose, so they move when the pose is refined.
+type wlsSample struct {
+    location *Location
+    pose *wlsPose
+    signalStrength float64
+}
+
+func (s wlsSample) position() *Location {
+    if s.pose != nil {
+        return s.pose.location
+    }
+    return s.location
+}
+
+// A wlsPose is a reading at an estimated receiver location
+type wlsPose struct {
+    signals Signals
+    location *Location
+}
+
+// The log distance path loss model of an access point: signal strength = power - 10 * exponent * log10(distance + 1)
+type wlsAccessPoint struct {
+    surveyed []wlsSample
+    learned []wlsSample
+    location *Location
+    power, exponent float64
+    // Whether the model is estimated, and whether there are samples that are not part of the estimate
+    fitted, dirty bool
+}
+
+// wls estimates the location and path loss of every access point from the signal strengths at the seed locations,
+// by weighted least squares. Reads estimate the receiver location from the signal strengths by weighted least squares.
+// When learning, the estimated receiver locations are kept, and are periodically refined together with the access points.
+type wls struct {
+    accessPoints map[int]*wlsAccessPoint
+    learning bool
+    poses []*wlsPose
+    reads int
+    // Access points are estimated once all seed readings are fed, and after every feed from then on
+    seeding bool
+}
+
+func NewWLSLocalization() algorithm {
+    return &wls{make(map[int]*wlsAccessPoint), false, nil, 0, true}
+}
+
+func NewLearningWLSLocalization() algorithm {
+    return &wls{make(map[int]*wlsAccessPoint), true, nil, 0, true}
+}
+
+func (w *wls) sequential() bool {
+    return w.learning
+}
+
+func (w *wls) accessPoint(id int) *wlsAccessPoint {
+    accessPoint, exists := w.accessPoints[id]
+    if !exists {
+        accessPoint = &wlsAccessPoint{}
+        w.accessPoints[id] = accessPoint
+    }
+    return accessPoint
+}
+
+func (w *wls) feed(signals Signals, location *Location) {
+    for _, signal := range signals {
+        accessPoint := w.accessPoint(signal.id)
+        accessPoint.surveyed = append(accessPoint.surveyed, wlsSample{location, nil, signal.signalStrength})
+        accessPoint.dirty = true
+    }
+    if !w.seeding {
+        w.fitDirty()
+    }
+}
+
+func (w *wls) seeded() {
+    w.seeding = false
+    w.fitDirty()
+}
+
+// Estimates the access points that have new samples
+func (w *wls) fitDirty() {
+    for _, accessPoint := range w.accessPoints {
+        if accessPoint.dirty {
+            accessPoint.fit()
+        }
+    }
+}
+
+func (w *wls) read(signals Signals, realLocation *Location) (*Estimate, bool) {
+    estimate := w.locate(signals)
+    if estimate == nil {
+        return nil, false
+    }
+    if w.learning {
+        w.learn(signals, estimate.Location)
+    }
+    return estimate, true
+}
+
+// Keeps the reading at the estimated location, and refines the access points and receiver locations every refine interval
+func (w *wls) learn(signals Signals, location *Location) {
+    pose := &wlsPose{make(Signals, len(signals)), NewLocation(location.X, location.Y)}
+    copy(pose.signals, signals)
+    w.poses = append(w.poses, pose)
+    if len(w.poses) > maxPoses {
+        w.poses = w.poses[len(w.poses) - maxPoses:]
+    }
+    w.reads += 1
+    if w.reads % refineInterval == 0 {
+        w.refine()
+    }
+}
+
+// Refines the access points and the receiver locations together, by alternately estimating the access points
+// from all samples, and the receiver locations from the access points
+func (w *wls) refine() {
+    for _, accessPoint := range w.accessPoints {
+        accessPoint.learned = accessPoint.learned[:0]
+    }
+    for _, pose := range w.poses {
+        for _, signal := range pose.signals {
+            accessPoint := w.accessPoint(signal.id)
+            accessPoint.learned = append(accessPoint.learned, wlsSample{nil, pose, signal.signalStrength})
+        }
+    }
+    for i := 0; i < refineIterations; i++ {
+        for _, accessPoint := range w.accessPoints {
+            accessPoint.fit()
+        }
+        for _, pose := range w.poses {
+            if estimate := w.locate(pose.signals); estimate != nil {
+                pose.location = estimate.Location
+            }
+        }
+    }
+}
+
+// Estimates the location, transmit power and path loss exponent of the access point from its samples
+func (accessPoint *wlsAccessPoint) fit() {
+    accessPoint.dirty = false
+    samples := make([]wlsSample, 0, len(accessPoint.surveyed) + len(accessPoint.learned))
+    samples = append(samples, accessPoint.surveyed...)
+    samples = append(samples, accessPoint.learned...)
+    if len(samples) < minWLSSamples {
+        return
+    }
+
+    // Stronger samples are closer to the access point, and weigh more
+    strongest := math.Inf(-1)
+    for _, sample := range samples {
+        strongest = math.Max(strongest, sample.signalStrength)
+    }
+    weights := make([]float64, len(samples))
+    locations := make([]*Location, len(samples))
+    for i, sample := range samples {
+        weights[i] = math.Pow(10, (sample.signalStrength - strongest) / 20)
+        if sample.pose != nil {
+            weights[i] *= learnedSampleWeight
+        }
+        locations[i] = sample.position()
+    }
+
+    signalStrengths := make([]float64, len(samples))
+    for i, sample := range samples {
+        signalStrengths[i] = sample.signalStrength
+    }
+    powers := make([]float64, len(samples))
+    exponents := make([]float64, len(samples))
+
+    location := weightedAverage(locations, weights)
+    power, exponent := -40.0, 2.0
+    for iteration := 0; iteration < wlsIterations; iteration++ {
+        // With the location fixed, the power and exponent follow from a linear fit
+        var sw, sx, sy, sxx, sxy float64
+        for i, sample := range samples {
+            x := -10 * math.Log10(distance(location, locations[i]) + 1)
+            sw += weights[i]
+            sx += weights[i] * x
+            sy += weights[i] * sample.signalStrength
+            sxx += weights[i] * x * x
+            sxy += weights[i] * x * sample.signalStrength
+        }
+        if variance := sxx * sw - sx * sx; variance > 1e-9 {
+            exponent = math.Max(minPathLossExponent, math.Min(maxPathLossExponent, (sxy * sw - sx * sy) / variance))
+        }
+        power = (sy - exponent * sx) / sw
+
+        // With the power and exponent fixed, the location follows from a Gauss-Newton step
+        for i, _ := range samples {
+            powers[i] = power
+            exponents[i] = exponent
+        }
+        location = gaussNewtonStep(location, locations, signalStrengths, weights, powers, exponents)
+    }
+    accessPoint.location = location
+    accessPoint.power = power
+    accessPoint.exponent = exponent
+    accessPoint.fitted = true
+}
+
+// Estimates the receiver location from the signals of the access points with an estimated model.
+// Returns nil if none of the access points of the signals has an estimated model.
+func (w *wls) locate(signals Signals) *Estimate {
+    var locations []*Location
+    var signalStrengths, weights, powers, exponents []float64
+    strongest := math.Inf(-1)
+    for _, signal := range signals {
+        strongest = math.Max(strongest, signal.signalStrength)
+    }
+    for _, signal := range signals {
+        accessPoint, exists := w.accessPoints[signal.id]
+        if !exists || !accessPoint.fitted {
+            continue
+        }
+        locations = append(locations, accessPoint.location)
+        signalStrengths = append(signalStrengths, signal.signalStrength)
+        weights = append(weights, math.Pow(10, (signal.signalStrength - strongest) / 20))
+        powers = append(powers, accessPoint.power)
+        exponents = append(exponents, accessPoint.exponent)
+    }
+    if len(locations) == 0 {
+        return nil
+    }
+    location := weightedAverage(locations, weights)
+    // With fewer than three access points the location is not determined, and the weighted centroid is used
+    if len(locations) < 3 {
+        return &Estimate{location, maxReceptionDistance / math.Sqrt2, len(locations), 0, nil, nil}
+    }
+    for iteration := 0; iteration < wlsIterations; iteration++ {
+        location = gaussNewtonStep(location, locations, signalStrengths, weights, powers, exponents)
+    }
+
+    // The expected error follows from the covariance of the fit, scaled by the weighted residual variance
+    var residuals, total, jxx, jxy, jyy float64
+    for i, accessPoint := range locations {
+        d := distance(location, accessPoint)
+        residual := signalStrengths[i] - (powers[i] - 10 * exponents[i] * math.Log10(d + 1))
+        gx, gy := pathLossGradient(location, accessPoint, exponents[i])
+        residuals += weights[i] * residual * residual
+        total += weights[i]
+        jxx += weights[i] * gx * gx
+        jxy += weights[i] * gx * gy
+        jyy += weights[i] * gy * gy
+    }
+    variance := residuals / total * float64(len(locations)) / float64(len(locations) - 2)
+    determinant := jxx * jyy - jxy * jxy
+    radius := maxReceptionDistance
+    if determinant > 0 {
+        radius = math.Min(radius, math.Sqrt(variance * (jxx + jyy) / determinant))
+    }
+    return &Estimate{location, radius, len(locations), math.Sqrt(residuals / total), nil, nil}
+}
+
+// Returns the gradient of the path loss model with respect to the location, for a signal between the location and the anchor
+func pathLossGradient(location, anchor *Location, exponent float64) (float64, float64) {
+    d := distance(location, anchor)
+    if d < 1e-6 {
+        return 0, 0
+    }
+    scale := -10 * exponent / (math.Ln10 * (d + 1) * d)
+    return scale * (location.X - anchor.X), scale * (location.Y - anchor.Y)
+}
+
+// Returns the location after a damped Gauss-Newton step that fits the path loss model to the signal strengths
+// between the location and the anchors. The step is at most maxWLSStep.
+func gaussNewtonStep(location *Location, anchors []*Location, signalStrengths, weights, powers, exponents []float64) *Location {
+    var jxx, jxy, jyy, bx, by float64
+    for i, anchor := range anchors {
+        d := distance(location, anchor)
+        residual := signalStrengths[i] - (powers[i] - 10 * exponents[i] * math.Log10(d + 1))
+        gx, gy := pathLossGradient(location, anchor, exponents[i])
+        jxx += weights[i] * gx * gx
+        jxy += weights[i] * gx * gy
+        jyy += weights[i] * gy * gy
+        bx += weights[i] * gx * residual
+        by += weights[i] * gy * residual
+    }
+    damping := 1e-3 * (jxx + jyy) + 1e-12
+    jxx += damping
+    jyy += damping
+    determinant := jxx * jyy - jxy * jxy
+    if determinant <= 0 {
+        return location
+    }
+    dx := (jyy * bx - jxy * by) / determinant
+    dy := (jxx * by - jxy * bx) / determinant
+    if step := math.Sqrt(dx * dx + dy * dy); step > maxWLSStep {
+        dx, dy = dx * maxWLSStep / step, dy * maxWLSStep / step
+    }
+    return NewLocation(location.X + dx, location.Y + dy)
+}
+
+// Returns the number of access points the algorithm holds data for
+func (w *wls) databaseSize() int {
+    return len(w.accessPoints)
+}
+
+// Returns the estimated access point locations, sorted by ID
+func (w *wls) accessPointEstimates() []AccessPointEstimate {
+    estimates := make([]AccessPointEstimate, 0, len(w.accessPoints))
+    for id, accessPoint := range w.accessPoints {
+        if accessPoint.fitted {
+            estimates = append(estimates, AccessPointEstimate{id, accessPoint.location, len(accessPoint.surveyed) + len(accessPoint.learned)})
+        }
+    }
+    sort.Sort(ByAccessPointID(estimates))
+    return estimates
+}